@@ -1,34 +1,252 @@
 package cpcache2go
 
 import (
+	"sort"
 	"sync"
 )
 
-var (
-	cache = make(map[string]*CacheTable)
-	mutex sync.RWMutex
-)
+// CacheManager is a registry of cache tables. The package level functions
+// like Cache use a default manager, separate managers allow independent sets
+// of tables e.g. in tests
+type CacheManager struct {
+	mutex  sync.RWMutex
+	tables map[string]*CacheTable
+}
+
+var defaultManager = NewCacheManager()
+
+// NewCacheManager return a new manager without any tables
+func NewCacheManager() *CacheManager {
+	return &CacheManager{tables: make(map[string]*CacheTable)}
+}
 
 // Cache return the existing cache table with the given name or creates a new one
 // if the table does not exist
 func Cache(table string) *CacheTable {
-	mutex.RLock()
-	t, ok := cache[table]
-	mutex.RUnlock()
+	return defaultManager.Cache(table)
+}
+
+// LookupTable return the existing cache table with the given name. Unlike
+// Cache it doesn't create the table if it does not exist
+func LookupTable(table string) (*CacheTable, bool) {
+	return defaultManager.LookupTable(table)
+}
+
+// Tables return the sorted names of all existing cache tables
+func Tables() []string {
+	return defaultManager.Tables()
+}
+
+// DropTable flushes the cache table with the given name, removes it from the
+// cache and stops its timers. It returns false if the table does not exist
+func DropTable(table string) bool {
+	return defaultManager.DropTable(table)
+}
+
+// StopAll stops the expiration timers of all cache tables, see
+// CacheTable.Stop
+func StopAll() {
+	defaultManager.StopAll()
+}
+
+// FlushTables flushes the cache tables with the given names, names of tables
+// which don't exist are ignored. Like Flush, the about-to-delete callbacks are
+// not called
+func FlushTables(names ...string) {
+	defaultManager.FlushTables(names...)
+}
+
+// FlushAll flushes all cache tables, see FlushTables
+func FlushAll() {
+	defaultManager.FlushAll()
+}
+
+// RenameTable moves the cache table registered under oldName to newName.
+// References to the table stay valid, only the name changes
+func RenameTable(oldName, newName string) error {
+	return defaultManager.RenameTable(oldName, newName)
+}
+
+// Cache return the existing cache table of the manager with the given name or
+// creates a new one if the table does not exist
+func (m *CacheManager) Cache(table string) *CacheTable {
+	m.mutex.RLock()
+	t, ok := m.tables[table]
+	m.mutex.RUnlock()
 
 	if !ok {
-		mutex.Lock()
+		m.mutex.Lock()
 		// double check if the table exists or not
-		t, ok = cache[table]
+		t, ok = m.tables[table]
 		if !ok {
-			t = &CacheTable{
-				name:  table,
-				items: make(map[interface{}]*CacheItem),
-			}
-			cache[table] = t
+			t = newCacheTable(table)
+			t.manager = m
+			m.tables[table] = t
 		}
-		mutex.Unlock()
+		m.mutex.Unlock()
 	}
 
 	return t
 }
+
+// LookupTable return the existing cache table of the manager with the given
+// name, see the package level LookupTable
+func (m *CacheManager) LookupTable(table string) (*CacheTable, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	t, ok := m.tables[table]
+
+	return t, ok
+}
+
+// Tables return the sorted names of all tables of the manager
+func (m *CacheManager) Tables() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	names := make([]string, 0, len(m.tables))
+	for name := range m.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DropTable flushes the table of the manager with the given name, removes it
+// from the manager and stops its timers. It returns false if the table does
+// not exist
+func (m *CacheManager) DropTable(table string) bool {
+	m.mutex.Lock()
+	t, ok := m.tables[table]
+	delete(m.tables, table)
+	m.mutex.Unlock()
+
+	if !ok {
+		return false
+	}
+	t.Flush()
+	t.Stop()
+
+	return true
+}
+
+// StopAll stops the expiration timers of all tables of the manager
+func (m *CacheManager) StopAll() {
+	m.mutex.RLock()
+	tables := make([]*CacheTable, 0, len(m.tables))
+	for _, t := range m.tables {
+		tables = append(tables, t)
+	}
+	m.mutex.RUnlock()
+
+	for _, t := range tables {
+		t.Stop()
+	}
+}
+
+// FlushTables flushes the tables of the manager with the given names, see the
+// package level FlushTables
+func (m *CacheManager) FlushTables(names ...string) {
+	m.mutex.RLock()
+	tables := make([]*CacheTable, 0, len(names))
+	for _, name := range names {
+		if t, ok := m.tables[name]; ok {
+			tables = append(tables, t)
+		}
+	}
+	m.mutex.RUnlock()
+
+	for _, t := range tables {
+		t.Flush()
+	}
+}
+
+// FlushAll flushes all tables of the manager
+func (m *CacheManager) FlushAll() {
+	m.mutex.RLock()
+	tables := make([]*CacheTable, 0, len(m.tables))
+	for _, t := range m.tables {
+		tables = append(tables, t)
+	}
+	m.mutex.RUnlock()
+
+	for _, t := range tables {
+		t.Flush()
+	}
+}
+
+// RenameTable moves the table of the manager registered under oldName to
+// newName, see the package level RenameTable
+func (m *CacheManager) RenameTable(oldName, newName string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	t, ok := m.tables[oldName]
+	if !ok {
+		return ErrTableNotFound
+	}
+	if _, ok := m.tables[newName]; ok {
+		return ErrTableExists
+	}
+	delete(m.tables, oldName)
+	m.tables[newName] = t
+
+	t.Lock()
+	t.name = newName
+	t.Unlock()
+
+	return nil
+}
+
+// Clone copies all items of the table into a new cache table registered under
+// newName in the same manager. Like with RenameTable, ErrTableExists is
+// returned if the name is taken. Items are copied with their timestamps,
+// access counts and metadata, but the data is shared between both tables. The
+// clone uses the same clock, callbacks are not copied
+func (table *CacheTable) Clone(newName string) (*CacheTable, error) {
+	m := table.manager
+	if m == nil {
+		m = defaultManager
+	}
+	if _, ok := m.LookupTable(newName); ok {
+		return nil, ErrTableExists
+	}
+	t := newCacheTable(newName)
+	t.manager = m
+
+	table.RLock()
+	t.clock = table.clock
+	items := make([]*CacheItem, 0, len(table.items))
+	for _, item := range table.items {
+		item.RLock()
+		items = append(items, &CacheItem{
+			key:         item.key,
+			data:        item.data,
+			lifeSpan:    item.lifeSpan,
+			createdOn:   item.createdOn,
+			accessedOn:  item.accessedOn,
+			accessCount: item.accessCount,
+			tags:        item.tags,
+			meta:        item.metaInternal(),
+			mode:        item.mode,
+			expireAt:    item.expireAt,
+			queueIndex:  -1,
+		})
+		item.RUnlock()
+	}
+	table.RUnlock()
+	t.AddBatch(items)
+
+	m.mutex.Lock()
+	if _, ok := m.tables[newName]; ok {
+		// taken while copying
+		m.mutex.Unlock()
+		t.Stop()
+		return nil, ErrTableExists
+	}
+	m.tables[newName] = t
+	m.mutex.Unlock()
+
+	return t, nil
+}
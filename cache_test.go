@@ -0,0 +1,171 @@
+package cpcache2go
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDropTable(t *testing.T) {
+	m := NewCacheManager()
+	for _, name := range []string{"c", "a", "b"} {
+		m.Cache(name).Add("key", 0, name)
+	}
+	dropped := m.Cache("b")
+	dropped.SetAccessDecay(0.5, time.Minute)
+
+	if !m.DropTable("b") {
+		t.Fatal("expected b to be dropped")
+	}
+	if m.DropTable("b") {
+		t.Error("dropped b twice")
+	}
+	if names := m.Tables(); !reflect.DeepEqual(names, []string{"a", "c"}) {
+		t.Errorf("expected tables [a c], got %v", names)
+	}
+	if dropped.Count() != 0 {
+		t.Error("dropped table was not flushed")
+	}
+	if m.Cache("b") == dropped {
+		t.Error("dropped table is still registered")
+	}
+	dropped.RLock()
+	defer dropped.RUnlock()
+	if !dropped.stopped || dropped.decayTimer != nil {
+		t.Error("timers of the dropped table were not stopped")
+	}
+}
+
+func TestClone(t *testing.T) {
+	m := NewCacheManager()
+	table := m.Cache("original")
+	table.Add("a", time.Minute, 1)
+	table.Add("b", 0, 2)
+	table.Value("a")
+	var added int
+	table.SetAddedItemCallback(func(item *CacheItem) { added++ })
+
+	clone, err := table.Clone("clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := m.LookupTable("clone"); !ok || c != clone {
+		t.Fatal("clone is not registered")
+	}
+	r, ok := clone.Peek("a")
+	if !ok || r.AccessCount() != 1 || r.LifeSpan() != time.Minute {
+		t.Fatalf("item not copied with its state: %v", r)
+	}
+
+	clone.Add("c", 0, 3)
+	clone.Delete("a")
+	if table.Count() != 2 || !table.Exists("a") || table.Exists("c") {
+		t.Error("mutating the clone changed the original")
+	}
+	if added != 0 {
+		t.Error("callbacks were copied to the clone")
+	}
+	table.Delete("b")
+	if !clone.Exists("b") {
+		t.Error("mutating the original changed the clone")
+	}
+
+	if _, err := table.Clone("clone"); err != ErrTableExists {
+		t.Errorf("expected ErrTableExists, got %v", err)
+	}
+	if c, _ := m.LookupTable("clone"); c != clone {
+		t.Error("cloning replaced the existing table")
+	}
+}
+
+func TestCloneClock(t *testing.T) {
+	clock := newFakeClock()
+	table := NewCacheManager().Cache("original")
+	table.SetClock(clock)
+	table.Add("key", time.Minute, "data")
+
+	clone, err := table.Clone("clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	waitFor(t, func() bool { return !clone.Exists("key") })
+}
+
+func TestRenameTable(t *testing.T) {
+	m := NewCacheManager()
+	table := m.Cache("tenant-1")
+	table.Add("key", 0, "data")
+	m.Cache("taken")
+
+	if err := m.RenameTable("tenant-1", "taken"); err != ErrTableExists {
+		t.Errorf("expected ErrTableExists, got %v", err)
+	}
+	if err := m.RenameTable("missing", "other"); err != ErrTableNotFound {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+	if err := m.RenameTable("tenant-1", "tenant-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the old pointer keeps working
+	if r, err := table.Value("key"); err != nil || r.Data() != "data" {
+		t.Errorf("expected the item via the old pointer, got %v, %v", r, err)
+	}
+	if table.name != "tenant-2" {
+		t.Errorf("expected name tenant-2, got %q", table.name)
+	}
+	if renamed, ok := m.LookupTable("tenant-2"); !ok || renamed != table {
+		t.Error("table not registered under the new name")
+	}
+	if _, ok := m.LookupTable("tenant-1"); ok {
+		t.Error("table still registered under the old name")
+	}
+}
+
+func TestCacheManagersAreIndependent(t *testing.T) {
+	m1, m2 := NewCacheManager(), NewCacheManager()
+	t1 := m1.Cache("shared")
+	t1.Add("key", 0, "data")
+
+	if _, ok := m2.LookupTable("shared"); ok {
+		t.Fatal("table of one manager visible in the other")
+	}
+	t2 := m2.Cache("shared")
+	if t2 == t1 || t2.Exists("key") {
+		t.Error("managers share a table")
+	}
+	if _, ok := LookupTable("shared"); ok {
+		t.Error("table visible in the default manager")
+	}
+
+	m2.DropTable("shared")
+	if !reflect.DeepEqual(m1.Tables(), []string{"shared"}) {
+		t.Errorf("dropping from one manager affected the other: %v", m1.Tables())
+	}
+}
+
+func TestFlushAll(t *testing.T) {
+	m := NewCacheManager()
+	names := []string{"a", "b", "c"}
+	for _, name := range names {
+		for i := 0; i < 10; i++ {
+			m.Cache(name).Add(i, 0, i)
+		}
+	}
+
+	m.FlushTables("a", "missing")
+	if m.Cache("a").Count() != 0 || m.Cache("b").Count() != 10 {
+		t.Error("FlushTables flushed the wrong tables")
+	}
+
+	m.FlushAll()
+	for _, name := range names {
+		if n := m.Cache(name).Count(); n != 0 {
+			t.Errorf("table %s: expected no items, got %d", name, n)
+		}
+	}
+	if !reflect.DeepEqual(m.Tables(), names) {
+		t.Errorf("flushing removed tables: %v", m.Tables())
+	}
+}
@@ -5,6 +5,17 @@ import (
 	"time"
 )
 
+// ExpirationMode selects what the lifespan of an item is measured from
+type ExpirationMode int
+
+const (
+	// IdleTimeout expires items which weren't accessed for their lifespan
+	IdleTimeout ExpirationMode = iota
+	// CreationTTL expires items their lifespan after they were created.
+	// Accesses and updates don't extend their life
+	CreationTTL
+)
+
 // CacheItem is an individual cache item
 // Parameter data contains the user-set value in the cache
 type CacheItem struct {
@@ -16,6 +27,10 @@ type CacheItem struct {
 	data interface{}
 	// how long will the item live in the cache when not being acceseed/kept alive
 	lifeSpan time.Duration
+	// what lifeSpan is measured from
+	mode ExpirationMode
+	// hard deadline capping the item's life, zero means none
+	expireAt time.Time
 
 	// creation timestamp
 	createdOn time.Time
@@ -24,13 +39,44 @@ type CacheItem struct {
 	// how often the item was accessed
 	accessCount int64
 
+	// tags the item was added with, immutable
+	tags []string
+	// pinned items are never evicted to make room
+	pinned bool
+	// bookkeeping attached by the user, separate from data
+	meta map[string]interface{}
+
 	// callback method triggered right before removing the item from the cache
+	// because its lifespan ran out
 	aboutToExpire func(key interface{})
+
+	// clock used for access timestamps
+	clock Clock
+
+	// approximate size in bytes, guarded by the table lock
+	size int64
+
+	// position in the table's expiration queue, guarded by the table lock
+	queueIndex int
+	// deadline the item was queued for, guarded by the table lock
+	queuedDeadline time.Time
+	// set while the item's delete callbacks run, guarded by the table lock
+	removing bool
+	// number of borrows not yet released, guarded by the table lock
+	borrows int
+	// delete callbacks postponed until the last borrower released the item,
+	// guarded by the table lock
+	onRelease func()
 }
 
 // NewCacheItem return a newly created CacheItem
 func NewCacheItem(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
-	t := time.Now()
+	return newCacheItem(realClock{}, key, lifeSpan, data)
+}
+
+// newCacheItem return a newly created CacheItem using the given clock
+func newCacheItem(clock Clock, key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
+	t := clock.Now()
 	return &CacheItem{
 		key:           key,
 		data:          data,
@@ -39,6 +85,8 @@ func NewCacheItem(key interface{}, lifeSpan time.Duration, data interface{}) *Ca
 		accessedOn:    t,
 		accessCount:   0,
 		aboutToExpire: nil,
+		clock:         clock,
+		queueIndex:    -1,
 	}
 }
 
@@ -46,16 +94,85 @@ func NewCacheItem(key interface{}, lifeSpan time.Duration, data interface{}) *Ca
 func (item *CacheItem) KeepAlive() {
 	item.Lock()
 	defer item.Unlock()
-	item.accessedOn = time.Now()
+	item.accessedOn = item.clock.Now()
+	item.accessCount++
+}
+
+// keepAliveFresh marks an item to be kept alive like KeepAlive, unless it is
+// past its deadline and only kept for the stale-while-revalidate window.
+// Keeping such an item alive would make its stale data fresh again
+func (item *CacheItem) keepAliveFresh(staleWindow time.Duration) {
+	item.Lock()
+	defer item.Unlock()
+	now := item.clock.Now()
+	if staleWindow > 0 && item.expiringInternal() && !now.Before(item.expiresInternal()) {
+		return
+	}
+	item.accessedOn = now
 	item.accessCount++
 }
 
 // LifeSpan returns the item's expiration duration
 func (item *CacheItem) LifeSpan() time.Duration {
-	// immutable
+	item.RLock()
+	defer item.RUnlock()
 	return item.lifeSpan
 }
 
+// RemainingLife returns how long the item has left before it expires, unless
+// it's kept alive. Returns 0 for items past their lifespan and -1 for items
+// which never expire
+func (item *CacheItem) RemainingLife() time.Duration {
+	item.RLock()
+	defer item.RUnlock()
+	if !item.expiringInternal() {
+		return -1
+	}
+	if r := item.expiresInternal().Sub(item.clock.Now()); r > 0 {
+		return r
+	}
+	return 0
+}
+
+// SetLifeSpan changes the item's expiration duration, keeping its access
+// statistics. Use CacheTable.UpdateLifeSpan for items stored in a table so the
+// expiration timer gets rescheduled
+func (item *CacheItem) SetLifeSpan(d time.Duration) {
+	item.Lock()
+	defer item.Unlock()
+	item.lifeSpan = d
+}
+
+// ExpirationMode return what the item's lifespan is measured from
+func (item *CacheItem) ExpirationMode() ExpirationMode {
+	item.RLock()
+	defer item.RUnlock()
+	return item.mode
+}
+
+// time the item expires unless it's kept alive, but no later than its hard
+// deadline. The method is internal and requires the item lock
+func (item *CacheItem) expiresInternal() time.Time {
+	var t time.Time
+	if item.lifeSpan > 0 {
+		if item.mode == CreationTTL {
+			t = item.createdOn.Add(item.lifeSpan)
+		} else {
+			t = item.accessedOn.Add(item.lifeSpan)
+		}
+	}
+	if !item.expireAt.IsZero() && (t.IsZero() || item.expireAt.Before(t)) {
+		t = item.expireAt
+	}
+	return t
+}
+
+// whether the item expires at all, the method is internal and requires the
+// item lock
+func (item *CacheItem) expiringInternal() bool {
+	return item.lifeSpan > 0 || !item.expireAt.IsZero()
+}
+
 // AccessedOn return when the item was last accessed
 func (item *CacheItem) AccessedOn() time.Time {
 	item.RLock()
@@ -84,12 +201,94 @@ func (item *CacheItem) Key() interface{} {
 
 // Data return the data of this cached item
 func (item *CacheItem) Data() interface{} {
-	// immutable
+	item.RLock()
+	defer item.RUnlock()
+	return item.dataInternal()
+}
+
+// Swap replaces the data of the item and returns the old data. Unlike
+// CacheTable.Replace it leaves the lifespan and timestamps untouched. Use
+// CacheTable.SwapValue for items stored in a table so their size is updated
+func (item *CacheItem) Swap(newData interface{}) (old interface{}) {
+	item.Lock()
+	defer item.Unlock()
+	old = item.dataInternal()
+	item.data = newData
+	return old
+}
+
+// data of the item with compression undone, the method is internal and
+// requires the item lock
+func (item *CacheItem) dataInternal() interface{} {
+	if c, ok := item.data.(*compressedData); ok {
+		return c.decompress()
+	}
 	return item.data
 }
 
+// Pin protects the item from being evicted when the table runs over its
+// capacity or byte budget. It can still be deleted and expire
+func (item *CacheItem) Pin() {
+	item.Lock()
+	defer item.Unlock()
+	item.pinned = true
+}
+
+// Unpin makes the item evictable again
+func (item *CacheItem) Unpin() {
+	item.Lock()
+	defer item.Unlock()
+	item.pinned = false
+}
+
+// Pinned return if the item is protected from eviction
+func (item *CacheItem) Pinned() bool {
+	item.RLock()
+	defer item.RUnlock()
+	return item.pinned
+}
+
+// SetMeta attaches a metadata value to the item, e.g. the source or etag of
+// its data
+func (item *CacheItem) SetMeta(key string, v interface{}) {
+	item.Lock()
+	defer item.Unlock()
+	if item.meta == nil {
+		item.meta = make(map[string]interface{})
+	}
+	item.meta[key] = v
+}
+
+// GetMeta returns a metadata value previously attached with SetMeta
+func (item *CacheItem) GetMeta(key string) (interface{}, bool) {
+	item.RLock()
+	defer item.RUnlock()
+	v, ok := item.meta[key]
+	return v, ok
+}
+
+// copy of the item's metadata, the method is internal and requires the item
+// lock
+func (item *CacheItem) metaInternal() map[string]interface{} {
+	if item.meta == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(item.meta))
+	for k, v := range item.meta {
+		m[k] = v
+	}
+	return m
+}
+
+// Tags return the tags the item was added with
+func (item *CacheItem) Tags() []string {
+	// immutable
+	return item.tags
+}
+
 // SetAboutToExpireCallback configure a callback, which will be called right
-// before the item is about to be removed from the cache
+// before the item is about to be removed from the cache because it expired.
+// It is not called when the item is deleted manually
 func (item *CacheItem) SetAboutToExpireCallback(f func(key interface{})) {
 	item.Lock()
 	defer item.Unlock()
@@ -0,0 +1,42 @@
+package cpcache2go
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	item := NewCacheItem("key", time.Minute, "data")
+	if _, ok := item.GetMeta("etag"); ok {
+		t.Error("expected no metadata on a new item")
+	}
+
+	item.SetMeta("etag", "abc")
+	item.SetMeta("source", "db")
+	item.KeepAlive()
+	if v, ok := item.GetMeta("etag"); !ok || v != "abc" {
+		t.Errorf("expected etag abc, got %v", v)
+	}
+	if item.Data() != "data" {
+		t.Errorf("metadata changed the data: %v", item.Data())
+	}
+}
+
+func TestMetaPersisted(t *testing.T) {
+	table := newCacheTable("meta")
+	table.Add("key", 0, "data").SetMeta("etag", "abc")
+
+	var buf bytes.Buffer
+	if err := table.SaveToWriter(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newCacheTable("loaded")
+	if err := loaded.LoadFromReader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := loaded.Peek("key")
+	if v, ok := r.GetMeta("etag"); !ok || v != "abc" {
+		t.Errorf("expected etag abc after a round-trip, got %v", v)
+	}
+}
@@ -1,258 +1,2410 @@
 package cpcache2go
 
 import (
+	"container/heap"
+	"container/list"
+	"context"
+	"fmt"
 	"log"
+	"log/slog"
+	"math"
+	"math/rand"
+	"reflect"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
-// CacheTable is a table in the cache
+// CacheTable is a table in the cache.
+// Callbacks like the added-item, about-to-delete and expire callbacks are
+// called without holding the table lock, so they may call any method of the
+// table. The item sizer and the functions passed to Foreach, Filter,
+// UpdateEach and the like run under the lock and must not call back into the
+// table
 type CacheTable struct {
 	sync.RWMutex
 
 	// the table's name
 	name string
+	// the manager the table is registered in, immutable
+	manager *CacheManager
 	// all cached items
 	items map[interface{}]*CacheItem
+	// items with a lifespan, ordered by their deadline
+	queue expirationQueue
+	// keys of the tagged items, by tag
+	tags map[string]map[interface{}]struct{}
 
 	// timer responsible for triggering cleanup
-	cleanupTimer *time.Timer
+	cleanupTimer Timer
 	// current timer duration
 	cleanupInterval time.Duration
+	// when the timer fires, zero if it's not armed
+	nextCleanup time.Time
+	// shortest duration the timer is armed for
+	minCleanupInterval time.Duration
+	// items are only expired when read, no timer is armed
+	lazyExpiration bool
+	// set by Stop, no expiration checks or decays run afterwards
+	stopped bool
+	// timer responsible for decaying the access counts
+	decayTimer Timer
+	// factor the access counts are multiplied by every decayInterval
+	decayFactor   float64
+	decayInterval time.Duration
 
 	// logger for the talbe
 	logger *log.Logger
+	// structured logger for the table, preferred over logger
+	slogger *slog.Logger
+	// source of time for timestamps and the cleanup timer
+	clock Clock
+
+	// usage counters
+	stats tableStats
+
+	// data-loader calls in progress, by key
+	loading map[interface{}]*loadCall
+	// fraction of the lifespan below which accessed items get reloaded
+	refreshAhead float64
+	// fraction of the lifespan below which Value keeps items alive
+	renewThreshold float64
+	// whether reads leave the access count and timestamp alone
+	untracked bool
+	// how long expired items are served while they are reloaded
+	staleWindow time.Duration
+	// how long keys the data-loader couldn't load are remembered
+	negativeTTL time.Duration
+	// deadlines of keys known to be absent
+	negative map[interface{}]time.Time
+	// size of negative which triggers a sweep of expired entries
+	negativeSweepAt int
+	// keys in insertion order and their list elements, nil unless enabled
+	order      *list.List
+	orderIndex map[interface{}]*list.Element
+	// callback method mapping keys to their canonical form
+	normalizeKey func(key interface{}) interface{}
+	// lifespan of items added without one
+	defaultLifeSpan time.Duration
+	// longest lifespan of added items, 0 means unlimited
+	maxLifeSpan time.Duration
+	// whether items added with a lifespan of 0 get maxLifeSpan
+	clampZeroLifeSpan bool
+	// fraction by which lifespans of added items are randomized
+	ttlJitter float64
+
+	// channels of the event subscribers
+	subscribers []chan CacheEvent
+	// channels of the watchers, by key
+	watchers map[interface{}][]chan WatchEvent
+
+	// callback method estimating the size of an item in bytes
+	sizer func(item *CacheItem) int64
+	// byte budget of the table, 0 means unlimited
+	maxBytes int64
+	// approximate size of all items in bytes
+	bytes int64
+	// size above which []byte data is compressed, 0 means never
+	compressMin int
+	// maximum number of items, 0 means unlimited
+	capacity int
+	// what to do when an add takes the table over capacity or byte budget
+	overflowPolicy OverflowPolicy
+	// whether items which never expire may be evicted
+	zeroLifeSpanEvictable bool
+	// how many items are compared to find a victim, 0 means all
+	evictionSampleSize int
+
+	// callback methods propagating additions and deletions to a backing store
+	onWrite  func(key, data interface{}) error
+	onDelete func(key interface{}) error
+	// batches additions and deletions for a backing store
+	writeBehind *writeBehind
 
 	// callback method triggered when trying to load a non-existing key
-	loadData func(key interface{}, args ...interface{}) *CacheItem
-	// callback method triggered when adding a new item to the cache
-	addedItem func(item *CacheItem)
-	// callback method triggered before deleting an item from the cache
-	aboutToDeleteItem func(item *CacheItem)
+	loadData loaderFunc
+	// how often the data-loader is tried per load
+	loaderAttempts int
+	// delay before the first retry of the data-loader, doubled per retry
+	loaderRetryDelay time.Duration
+	// hook wrapping the data-loader calls, e.g. in tracing spans
+	loaderTracer func(ctx context.Context, key interface{}) (context.Context, func(err error))
+	// table consulted when the data-loader could not load a key
+	fallback *CacheTable
+	// callback method triggered when trying to load several non-existing keys
+	loadBatch func(keys []interface{}) map[interface{}]*CacheItem
+	// callback methods triggered when adding a new item to the cache
+	addedItem []func(item *CacheItem)
+	// callback methods triggered before deleting an item from the cache
+	aboutToDeleteItem []func(item *CacheItem)
+	// callback method triggered before removing an expired item from the cache
+	expiredItem func(item *CacheItem)
+	// callback method triggered with all items removed by an expiration check
+	expiredBatch func(items []*CacheItem)
+	// callback method triggered before removing an item, with the reason
+	evictedItem func(item *CacheItem, reason EvictReason)
+	// handler for panics of the callbacks
+	panicHandler func(recovered interface{})
+	// callback method triggered after the data-loader added an item
+	loadedItem func(item *CacheItem)
+}
+
+// newCacheTable return a new empty table, which is not registered in the cache
+func newCacheTable(name string) *CacheTable {
+	return &CacheTable{
+		name:     name,
+		items:    make(map[interface{}]*CacheItem),
+		tags:     make(map[string]map[interface{}]struct{}),
+		loading:  make(map[interface{}]*loadCall),
+		negative: make(map[interface{}]time.Time),
+		clock:    realClock{},
+	}
+}
+
+// Count return how many items are currently stored in the cache
+func (table *CacheTable) Count() int {
+	table.RLock()
+	defer table.RUnlock()
+	return len(table.items)
+}
+
+// Foreach all items in the table
+func (table *CacheTable) Foreach(trans func(k interface{}, item *CacheItem)) {
+	table.RLock()
+	defer table.RUnlock()
+
+	table.rangeInternal(func(k interface{}, v *CacheItem) bool {
+		trans(k, v)
+		return true
+	})
+}
+
+// ForeachUntil visits the items in the table until trans returns false
+func (table *CacheTable) ForeachUntil(trans func(k interface{}, item *CacheItem) bool) {
+	table.RLock()
+	defer table.RUnlock()
+
+	table.rangeInternal(trans)
+}
+
+// Filter returns a snapshot of all items for which pred returns true
+func (table *CacheTable) Filter(pred func(item *CacheItem) bool) []*CacheItem {
+	table.RLock()
+	defer table.RUnlock()
+
+	var r []*CacheItem
+	for _, v := range table.items {
+		if pred(v) {
+			r = append(r, v)
+		}
+	}
+
+	return r
+}
+
+// CountWhere returns how many items satisfy pred
+func (table *CacheTable) CountWhere(pred func(item *CacheItem) bool) int {
+	table.RLock()
+	defer table.RUnlock()
+
+	n := 0
+	for _, v := range table.items {
+		if pred(v) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// GroupBy returns a snapshot of all items partitioned by the group fn
+// assigns them to
+func (table *CacheTable) GroupBy(fn func(item *CacheItem) string) map[string][]*CacheItem {
+	table.RLock()
+	defer table.RUnlock()
+
+	groups := make(map[string][]*CacheItem)
+	for _, v := range table.items {
+		group := fn(v)
+		groups[group] = append(groups[group], v)
+	}
+
+	return groups
+}
+
+// SumInt64 returns the sum of the values extract returns for all items
+func (table *CacheTable) SumInt64(extract func(item *CacheItem) int64) int64 {
+	table.RLock()
+	defer table.RUnlock()
+
+	var sum int64
+	for _, v := range table.items {
+		sum += extract(v)
+	}
+
+	return sum
+}
+
+// ExpiringWithin returns a snapshot of the items which will expire within d
+// unless they are kept alive, e.g. to reload them ahead of time. Items which
+// never expire or already expired are left out
+func (table *CacheTable) ExpiringWithin(d time.Duration) []*CacheItem {
+	table.RLock()
+	defer table.RUnlock()
+
+	var r []*CacheItem
+	for _, v := range table.items {
+		if remaining := v.RemainingLife(); remaining > 0 && remaining <= d {
+			r = append(r, v)
+		}
+	}
+
+	return r
+}
+
+// Keys returns a snapshot of all keys currently stored in the table.
+// The read lock is only held while copying, so the keys may be stale by the
+// time the caller uses them. Unlike Foreach it is safe to call Delete while
+// ranging over the returned slice
+func (table *CacheTable) Keys() []interface{} {
+	table.RLock()
+	defer table.RUnlock()
+
+	keys := make([]interface{}, 0, len(table.items))
+	table.rangeInternal(func(k interface{}, _ *CacheItem) bool {
+		keys = append(keys, k)
+		return true
+	})
+
+	return keys
+}
+
+// Values returns a point-in-time snapshot of the data of all items, see Keys
+func (table *CacheTable) Values() []interface{} {
+	table.RLock()
+	defer table.RUnlock()
+
+	values := make([]interface{}, 0, len(table.items))
+	table.rangeInternal(func(_ interface{}, v *CacheItem) bool {
+		values = append(values, v.Data())
+		return true
+	})
+
+	return values
+}
+
+// Items returns a point-in-time snapshot of all items, see Keys
+func (table *CacheTable) Items() []*CacheItem {
+	table.RLock()
+	defer table.RUnlock()
+
+	items := make([]*CacheItem, 0, len(table.items))
+	table.rangeInternal(func(_ interface{}, v *CacheItem) bool {
+		items = append(items, v)
+		return true
+	})
+
+	return items
+}
+
+// SetDataLoader configure a data-loader callback, which will be called when
+// trying to access a non-exisiting key. The key and 0...n additional arguments
+// are passed to the callback function
+func (table *CacheTable) SetDataLoader(f func(interface{}, ...interface{}) *CacheItem) {
+	table.SetDataLoaderContext(func(_ context.Context, key interface{}, args ...interface{}) *CacheItem {
+		return f(key, args...)
+	})
+}
+
+// SetDataLoaderContext configure a data-loader callback like SetDataLoader,
+// which additionally receives the context passed to ValueContext
+func (table *CacheTable) SetDataLoaderContext(f func(context.Context, interface{}, ...interface{}) *CacheItem) {
+	table.Lock()
+	defer table.Unlock()
+	table.loadData = func(ctx context.Context, key interface{}, args ...interface{}) (*CacheItem, bool) {
+		return f(ctx, key, args...), true
+	}
+}
+
+// SetDataLoaderEx configure a data-loader callback like SetDataLoader, which
+// additionally reports whether the returned item may be cached. Items it
+// returns with false are passed on to the caller, but not added to the table
+func (table *CacheTable) SetDataLoaderEx(f func(interface{}, ...interface{}) (*CacheItem, bool)) {
+	table.Lock()
+	defer table.Unlock()
+	table.loadData = func(_ context.Context, key interface{}, args ...interface{}) (*CacheItem, bool) {
+		return f(key, args...)
+	}
+}
+
+// SetBatchDataLoader configure a data-loader callback, which ValueMany calls
+// once with all keys it could not find. Keys missing from the returned map
+// could not be loaded. Without it, ValueMany calls the data-loader configured
+// with SetDataLoader for every missing key
+func (table *CacheTable) SetBatchDataLoader(f func(keys []interface{}) map[interface{}]*CacheItem) {
+	table.Lock()
+	defer table.Unlock()
+	table.loadBatch = f
+}
+
+// SetFallbackTable configure a table, usually a bigger one with longer
+// lifespans, which Value consults when the data-loader could not load a key.
+// An item found there is returned along with ErrStale
+func (table *CacheTable) SetFallbackTable(fallback *CacheTable) {
+	table.Lock()
+	defer table.Unlock()
+	table.fallback = fallback
+}
+
+// SetRefreshAhead enables reloading items in the background via the
+// data-loader when they are accessed and their remaining life is below the
+// given fraction of their lifespan (e.g. 0.1 for the last 10%). The current
+// item is returned right away. A threshold of 0 disables refreshing
+func (table *CacheTable) SetRefreshAhead(threshold float64) {
+	table.Lock()
+	defer table.Unlock()
+	table.refreshAhead = threshold
+}
+
+// SetTrackAccess configures whether reads like Value and Get count as an
+// access of the item, which is the default. With tracking disabled reads don't
+// modify the items, which reduces contention on read-heavy tables. Items then
+// expire their lifespan after they were added or updated, and the eviction
+// policies degrade to evicting the least recently written items. Touch still
+// keeps items alive explicitly
+func (table *CacheTable) SetTrackAccess(track bool) {
+	table.Lock()
+	defer table.Unlock()
+	table.untracked = !track
+}
+
+// SetStaleWhileRevalidate keeps expired items in the table for another
+// window. Value keeps returning such a stale item right away and reloads it in
+// the background via the data-loader, instead of blocking on the reload. The
+// item is removed once the window ran out without a successful reload. Get and
+// Peek return stale items too, but reads and Touch never keep them alive. A
+// window of 0 disables it
+func (table *CacheTable) SetStaleWhileRevalidate(window time.Duration) {
+	table.Lock()
+	defer table.Unlock()
+	table.staleWindow = window
+}
+
+// SetRenewThreshold makes Value mark items to be kept alive only when their
+// remaining life is below the given fraction of their lifespan (e.g. 0.2 for
+// the last 20%). Reads earlier in an item's life neither update its access
+// time nor its access count, which saves locking the item. A threshold of 0
+// renews on every read
+func (table *CacheTable) SetRenewThreshold(fraction float64) {
+	table.Lock()
+	defer table.Unlock()
+	table.renewThreshold = fraction
 }
 
-// Count return how many items are currently stored in the cache
-func (table *CacheTable) Count() int {
-	table.RLock()
-	defer table.RUnlock()
-	return len(table.items)
+// SetMinCleanupInterval configure the shortest duration the expiration timer
+// is armed for. Items expiring close together are then removed in a single
+// pass, at the cost of being removed up to d late
+func (table *CacheTable) SetMinCleanupInterval(d time.Duration) {
+	table.Lock()
+	defer table.Unlock()
+	table.minCleanupInterval = d
+}
+
+// SetDefaultLifeSpan configure the lifespan used for items added with
+// AddDefault. Without a default, they never expire
+func (table *CacheTable) SetDefaultLifeSpan(d time.Duration) {
+	table.Lock()
+	defer table.Unlock()
+	table.defaultLifeSpan = d
+}
+
+// SetKeyNormalizer configure a callback mapping keys to their canonical form,
+// e.g. lowercasing strings, before they are looked up or stored. It must be
+// deterministic and idempotent. Maps returned by GetMany and ValueMany are
+// keyed by the normalized keys. Like the item sizer, it is called with the
+// table locked and must not call back into the table
+func (table *CacheTable) SetKeyNormalizer(f func(key interface{}) interface{}) {
+	table.Lock()
+	defer table.Unlock()
+	table.normalizeKey = f
+}
+
+// normalize return the canonical form of key, the table must not be locked by
+// the caller
+func (table *CacheTable) normalize(key interface{}) interface{} {
+	table.RLock()
+	defer table.RUnlock()
+	return table.normalizeInternal(key)
+}
+
+// normalizeInternal return the canonical form of key, the method is internal
+// and requires the table lock
+func (table *CacheTable) normalizeInternal(key interface{}) interface{} {
+	if table.normalizeKey == nil {
+		return key
+	}
+	return table.normalizeKey(key)
+}
+
+// SetItemSizer configure a callback estimating the size of an item in bytes,
+// which is used to track the size of the table. It is called with the table
+// locked and must not call back into the table. The sizer should be set
+// before adding items, as items already in the table are not re-measured
+func (table *CacheTable) SetItemSizer(f func(item *CacheItem) int64) {
+	table.Lock()
+	defer table.Unlock()
+	table.sizer = f
+}
+
+// SetMaxBytes configure the byte budget of the table. When adding an item
+// takes the table over budget, items are evicted until it fits again or the
+// add is rejected, see SetOverflowPolicy. Requires an item sizer, 0 disables
+// the limit
+func (table *CacheTable) SetMaxBytes(n int64) {
+	table.Lock()
+	defer table.Unlock()
+	table.maxBytes = n
+}
+
+// Bytes return the approximate size of all items in the table as reported
+// by the item sizer
+func (table *CacheTable) Bytes() int64 {
+	table.RLock()
+	defer table.RUnlock()
+	return table.bytes
+}
+
+// SetWriteThrough configure callbacks propagating the changes of the table to
+// a backing store synchronously, see SetWriteBehind for the asynchronous mode.
+// onWrite is called for every item added or updated, e.g. by Add, AddBatch,
+// Import, Replace, Increment or UpdateEach, and onDelete for every item
+// deleted, e.g. by Delete, DeleteBatch, DeleteMatch or UpdateEach. They are
+// called with the table locked before the table is changed and must not call
+// back into the table. A failing callback leaves the item untouched. Items
+// loaded via the data-loader callback or restored by LoadFromReader and
+// ImportJSON are not written back, and expired, evicted or flushed items are
+// not deleted from the backing store
+func (table *CacheTable) SetWriteThrough(onWrite func(key, data interface{}) error, onDelete func(key interface{}) error) {
+	table.Lock()
+	defer table.Unlock()
+	table.onWrite = onWrite
+	table.onDelete = onDelete
+}
+
+// write an added or updated item to the backing store via the write-through
+// callback and queue it for the write-behind, the method is internal and
+// requires the table lock
+func (table *CacheTable) storeWriteInternal(key, data interface{}) error {
+	if table.onWrite != nil {
+		if err := table.onWrite(key, data); err != nil {
+			return err
+		}
+	}
+	if table.writeBehind != nil {
+		table.writeBehind.enqueue(Mutation{Op: MutationWrite, Key: key, Data: data})
+	}
+	return nil
+}
+
+// delete an item from the backing store via the write-through callback and
+// queue the deletion for the write-behind, the method is internal and
+// requires the table lock
+func (table *CacheTable) storeDeleteInternal(key interface{}) error {
+	if table.onDelete != nil {
+		if err := table.onDelete(key); err != nil {
+			return err
+		}
+	}
+	if table.writeBehind != nil {
+		table.writeBehind.enqueue(Mutation{Op: MutationDelete, Key: key})
+	}
+	return nil
+}
+
+// SetMaxLifeSpan configure the longest lifespan of added or updated items,
+// longer lifespans are cut down to d. Items with a lifespan of 0 still never
+// expire, see SetClampZeroLifeSpan. 0 disables the limit
+func (table *CacheTable) SetMaxLifeSpan(d time.Duration) {
+	table.Lock()
+	defer table.Unlock()
+	table.maxLifeSpan = d
+}
+
+// SetClampZeroLifeSpan configures whether items added with a lifespan of 0
+// get the maximum lifespan instead of never expiring, so a TTL-only cache
+// can't fill up with items that stay forever. Requires SetMaxLifeSpan
+func (table *CacheTable) SetClampZeroLifeSpan(clamp bool) {
+	table.Lock()
+	defer table.Unlock()
+	table.clampZeroLifeSpan = clamp
+}
+
+// SetTTLJitter randomizes the lifespan of added items within +/- fraction of
+// the requested lifespan (e.g. 0.1 for +/-10%), so items added together don't
+// all expire at the same instant. This applies to AddBatch, Import and
+// AddStream too, items restored by LoadFromReader or ImportJSON keep their
+// saved lifespan. The fraction is limited to [0, 1), so jittered lifespans stay
+// positive. A fraction of 0 disables jitter
+func (table *CacheTable) SetTTLJitter(fraction float64) {
+	switch {
+	case fraction < 0:
+		fraction = 0
+	case fraction >= 1:
+		fraction = math.Nextafter(1, 0)
+	}
+
+	table.Lock()
+	defer table.Unlock()
+	table.ttlJitter = fraction
+}
+
+// SetAddedItemCallback configure a callback, which will be called when
+// a new item is added to the cache. It replaces all previously configured
+// added-item callbacks
+func (table *CacheTable) SetAddedItemCallback(f func(item *CacheItem)) {
+	table.Lock()
+	defer table.Unlock()
+	table.addedItem = []func(item *CacheItem){f}
+}
+
+// AddAddedItemCallback appends a callback, which will be called when a new
+// item is added to the cache. Callbacks are invoked in registration order
+func (table *CacheTable) AddAddedItemCallback(f func(item *CacheItem)) {
+	table.Lock()
+	defer table.Unlock()
+	table.addedItem = append(table.addedItem, f)
+}
+
+// RemoveAddedItemCallbacks empties the added-item callback queue
+func (table *CacheTable) RemoveAddedItemCallbacks() {
+	table.Lock()
+	defer table.Unlock()
+	table.addedItem = nil
+}
+
+// SetAboutToDeleteItemCallback configures a callback, which will be called
+// every time an item is about to removed from the cache. It replaces all
+// previously configured about-to-delete callbacks
+func (table *CacheTable) SetAboutToDeleteItemCallback(f func(item *CacheItem)) {
+	table.Lock()
+	defer table.Unlock()
+	table.aboutToDeleteItem = []func(item *CacheItem){f}
+}
+
+// AddAboutToDeleteItemCallback appends a callback, which will be called every
+// time an item is about to removed from the cache. Callbacks are invoked in
+// registration order
+func (table *CacheTable) AddAboutToDeleteItemCallback(f func(item *CacheItem)) {
+	table.Lock()
+	defer table.Unlock()
+	table.aboutToDeleteItem = append(table.aboutToDeleteItem, f)
+}
+
+// RemoveAboutToDeleteItemCallbacks empties the about-to-delete callback queue
+func (table *CacheTable) RemoveAboutToDeleteItemCallbacks() {
+	table.Lock()
+	defer table.Unlock()
+	table.aboutToDeleteItem = nil
+}
+
+// SetExpireCallback configures a callback, which will be called every time an
+// item is about to be removed from the cache because its lifespan ran out.
+// Unlike the about-to-delete callbacks it is not called for manual deletes
+func (table *CacheTable) SetExpireCallback(f func(item *CacheItem)) {
+	table.Lock()
+	defer table.Unlock()
+	table.expiredItem = f
+}
+
+// SetExpiredBatchCallback configures a callback, which will be called once per
+// expiration check with all items it removed because their lifespan ran out.
+// It is called after the items were deleted, which is cheaper than the
+// per-item expire callback for big sweeps. Items expired lazily on read are
+// not included
+func (table *CacheTable) SetExpiredBatchCallback(f func(items []*CacheItem)) {
+	table.Lock()
+	defer table.Unlock()
+	table.expiredBatch = f
+}
+
+// SetEvictedItemCallback configures a callback, which will be called every
+// time an item is about to be removed from the cache because it was deleted,
+// expired or evicted to make room, telling which of them applies. It is not
+// called for flushed items
+func (table *CacheTable) SetEvictedItemCallback(f func(item *CacheItem, reason EvictReason)) {
+	table.Lock()
+	defer table.Unlock()
+	table.evictedItem = f
+}
+
+// SetLoadedItemCallback configures a callback, which will be called every time
+// an item loaded by the data-loader was added to the cache. The added-item
+// callbacks are called as well
+func (table *CacheTable) SetLoadedItemCallback(f func(item *CacheItem)) {
+	table.Lock()
+	defer table.Unlock()
+	table.loadedItem = f
+}
+
+// SetCallbackPanicHandler configure a handler, which is called with the value
+// recovered from a panicking callback. Panics of the added-item, loaded-item,
+// about-to-delete and expire callbacks are always recovered and logged, so
+// they can't break the table
+func (table *CacheTable) SetCallbackPanicHandler(f func(recovered interface{})) {
+	table.Lock()
+	defer table.Unlock()
+	table.panicHandler = f
+}
+
+// run a callback and recover from its panics, the table must not be locked by
+// the caller
+func (table *CacheTable) safeCall(f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			table.RLock()
+			handler := table.panicHandler
+			table.log("Recovered from panicking callback in table", table.name+":", r)
+			table.RUnlock()
+			if handler != nil {
+				handler(r)
+			}
+		}
+	}()
+
+	f()
+}
+
+// SetLogger configure the logger used by the table
+func (table *CacheTable) SetLogger(logger *log.Logger) {
+	table.Lock()
+	defer table.Unlock()
+	table.logger = logger
+}
+
+// SetSlogger configure a structured logger used by the table instead of the
+// logger set by SetLogger. Adding, deleting and expiring items is logged with
+// the attributes table, key, lifespan and access_count
+func (table *CacheTable) SetSlogger(logger *slog.Logger) {
+	table.Lock()
+	defer table.Unlock()
+	table.slogger = logger
+}
+
+// queue the item for expiration or move it to its current deadline, the
+// method is internal and requires the table lock
+func (table *CacheTable) scheduleInternal(item *CacheItem) {
+	item.RLock()
+	expiring := item.expiringInternal()
+	expires := item.expiresInternal()
+	item.RUnlock()
+
+	if !expiring {
+		table.unscheduleInternal(item)
+		return
+	}
+	item.queuedDeadline = expires.Add(table.staleWindow)
+	if table.queue.contains(item) {
+		heap.Fix(&table.queue, item.queueIndex)
+	} else {
+		heap.Push(&table.queue, item)
+	}
+}
+
+// remove the item from the expiration queue, the method is internal and
+// requires the table lock
+func (table *CacheTable) unscheduleInternal(item *CacheItem) {
+	if table.queue.contains(item) {
+		heap.Remove(&table.queue, item.queueIndex)
+	}
+}
+
+// store the item in the table, replacing any item with the same key, the
+// method is internal and requires the table lock
+func (table *CacheTable) insertInternal(item *CacheItem) {
+	if old, ok := table.items[item.key]; ok {
+		table.unindexInternal(old)
+	}
+	item.Lock()
+	item.clock = table.clock
+	item.data = table.compressInternal(item.data)
+	item.Unlock()
+	item.size = table.sizeInternal(item)
+	table.bytes += item.size
+	delete(table.negative, item.key)
+	table.items[item.key] = item
+	table.orderInternal(item.key)
+	table.scheduleInternal(item)
+	for _, tag := range item.tags {
+		keys, ok := table.tags[tag]
+		if !ok {
+			keys = make(map[interface{}]struct{})
+			table.tags[tag] = keys
+		}
+		keys[item.key] = struct{}{}
+	}
+}
+
+// remove the item from the table, the method is internal and requires the
+// table lock
+func (table *CacheTable) removeInternal(item *CacheItem) {
+	delete(table.items, item.key)
+	table.unorderInternal(item.key)
+	table.unindexInternal(item)
+}
+
+// remove the item from the expiration queue and the tag index, the method is
+// internal and requires the table lock
+func (table *CacheTable) unindexInternal(item *CacheItem) {
+	table.bytes -= item.size
+	table.unscheduleInternal(item)
+	for _, tag := range item.tags {
+		keys := table.tags[tag]
+		delete(keys, item.key)
+		if len(keys) == 0 {
+			delete(table.tags, tag)
+		}
+	}
+}
+
+// expiration check loop, triggered by a self-adjusting timer.
+// Items are taken from the expiration queue in deadline order, so a check
+// costs O(log n) per expired item instead of a scan over the whole table.
+// KeepAlive doesn't touch the queue: an item which was kept alive since it
+// was queued is simply requeued for its new deadline when it comes up.
+// It returns the number of expired items
+func (table *CacheTable) expirationCheck() int {
+	table.Lock()
+	if table.stopped {
+		table.Unlock()
+		return 0
+	}
+	if table.cleanupTimer != nil {
+		table.cleanupTimer.Stop()
+	}
+	if table.cleanupInterval > 0 {
+		table.log("Expiration check trigged after", table.cleanupInterval, "for table", table.name)
+	} else {
+		table.log("Expiration check installed for table", table.name)
+	}
+
+	now := table.clock.Now()
+	var expired []*CacheItem
+	for len(table.queue) > 0 && !table.queue[0].queuedDeadline.After(now) {
+		item := table.queue[0]
+		item.RLock()
+		expiring := item.expiringInternal()
+		expires := item.expiresInternal().Add(table.staleWindow)
+		item.RUnlock()
+
+		if expiring && now.Before(expires) {
+			// item was kept alive since it was queued
+			table.scheduleInternal(item)
+			continue
+		}
+		heap.Pop(&table.queue)
+		if !expiring {
+			continue
+		}
+		// item has exceeded its lifespan
+		if r, err := table.deleteInternal(item.key, removedExpired); err == nil {
+			table.stats.expirations.Add(1)
+			expired = append(expired, r)
+		}
+	}
+
+	// setup the interval for the next cleanup check, which is the item
+	// chronologically closest to its end-of-lifespan. The callbacks ran
+	// unlocked, so now may be stale
+	now = table.clock.Now()
+	smallestDuration := 0 * time.Second
+	if len(table.queue) > 0 {
+		smallestDuration = table.queue[0].queuedDeadline.Sub(now)
+		if smallestDuration < table.minCleanupInterval {
+			smallestDuration = table.minCleanupInterval
+		}
+	}
+	if table.cleanupTimer != nil {
+		// a concurrent check may have armed it while we were unlocked
+		table.cleanupTimer.Stop()
+	}
+	table.cleanupInterval = 0
+	table.nextCleanup = time.Time{}
+	if smallestDuration > 0 && !table.lazyExpiration {
+		table.cleanupTimer = table.clock.AfterFunc(smallestDuration, func() {
+			go table.expirationCheck()
+		})
+		table.cleanupInterval = smallestDuration
+		table.nextCleanup = now.Add(smallestDuration)
+	}
+	// cache value so we don't keep blocking the mutex
+	expiredBatch := table.expiredBatch
+	table.Unlock()
+
+	if expiredBatch != nil && len(expired) > 0 {
+		table.safeCall(func() { expiredBatch(expired) })
+	}
+
+	return len(expired)
+}
+
+// CleanupInterval return the duration the expiration timer was last armed
+// for, 0 if it's not armed
+func (table *CacheTable) CleanupInterval() time.Duration {
+	table.RLock()
+	defer table.RUnlock()
+	return table.cleanupInterval
+}
+
+// NextCleanup return when the expiration timer fires next, false if it's not
+// armed
+func (table *CacheTable) NextCleanup() (time.Time, bool) {
+	table.RLock()
+	defer table.RUnlock()
+	return table.nextCleanup, !table.nextCleanup.IsZero()
+}
+
+// DeleteExpired removes all items which exceeded their lifespan right away
+// instead of waiting for the expiration timer, and returns how many were
+// removed. The timer is re-armed for the next item to expire
+func (table *CacheTable) DeleteExpired() int {
+	return table.expirationCheck()
+}
+
+// SetLazyExpiration enables expiring items only when they are read by Value,
+// Get or Exists, or swept by DeleteExpired. No expiration timer runs in this
+// mode, which suits short-lived programs. Items nobody reads stay in the table
+// until the next sweep
+func (table *CacheTable) SetLazyExpiration(lazy bool) {
+	table.Lock()
+	table.lazyExpiration = lazy
+	if lazy && table.cleanupTimer != nil {
+		table.cleanupTimer.Stop()
+		table.cleanupTimer = nil
+	}
+	table.cleanupInterval = 0
+	table.nextCleanup = time.Time{}
+	table.Unlock()
+
+	if !lazy {
+		// arm the timer for the items added in the meantime
+		table.expirationCheck()
+	}
+}
+
+// delete an item which exceeded its lifespan when it's read in lazy
+// expiration mode and report whether it expired, the table must not be locked
+// by the caller
+func (table *CacheTable) expireOnRead(key interface{}, r *CacheItem) bool {
+	table.RLock()
+	staleWindow := table.staleWindow
+	table.RUnlock()
+	r.RLock()
+	expired := r.expiringInternal() && !r.clock.Now().Before(r.expiresInternal().Add(staleWindow))
+	r.RUnlock()
+	if !expired {
+		return false
+	}
+
+	table.Lock()
+	if table.items[key] == r {
+		if _, err := table.deleteInternal(key, removedExpired); err == nil {
+			table.stats.expirations.Add(1)
+		}
+	}
+	table.Unlock()
+
+	return true
+}
+
+// Stop stops the expiration and decay timers for good. Items don't expire
+// anymore afterwards, but the table can still be used otherwise. Use it for
+// tables which are discarded, so no timer touches them later
+func (table *CacheTable) Stop() {
+	table.Lock()
+	defer table.Unlock()
+
+	table.stopped = true
+	table.cleanupInterval = 0
+	table.nextCleanup = time.Time{}
+	if table.cleanupTimer != nil {
+		table.cleanupTimer.Stop()
+		table.cleanupTimer = nil
+	}
+	if table.decayTimer != nil {
+		table.decayTimer.Stop()
+		table.decayTimer = nil
+	}
+}
+
+// PurgeExpired removes all items which exceeded their lifespan like
+// DeleteExpired, and logs how many were purged. The purged items count
+// towards the expirations in Stats
+func (table *CacheTable) PurgeExpired() int {
+	n := table.expirationCheck()
+
+	table.RLock()
+	table.log("Purged", n, "expired items from table", table.name)
+	table.RUnlock()
+
+	return n
+}
+
+// replace the data and lifespan of a stored item and mark it to be kept alive,
+// the method is internal and requires the table lock. It reports whether the
+// expiration check needs to run for the new lifespan. The item is left
+// untouched if the lifespan is invalid, the data doesn't fit the byte budget
+// or the write-through callback fails. Other items may be evicted to make room
+func (table *CacheTable) updateInternal(item *CacheItem, data interface{}, lifeSpan time.Duration) (bool, error) {
+	lifeSpan, err := table.lifeSpanInternal(lifeSpan)
+	if err != nil {
+		return false, err
+	}
+	stored := table.compressInternal(data)
+	size, err := table.resizeInternal(item, stored)
+	if err != nil {
+		return false, err
+	}
+	if err := table.storeWriteInternal(item.key, data); err != nil {
+		return false, err
+	}
+	item.Lock()
+	item.data = stored
+	item.lifeSpan = lifeSpan
+	item.accessedOn = table.clock.Now()
+	item.Unlock()
+
+	table.bytes += size - item.size
+	item.size = size
+	table.scheduleInternal(item)
+	table.emitInternal(EventUpdated, item.key)
+	table.evictInternal(item)
+
+	return lifeSpan > 0 && table.imminentInternal(lifeSpan), nil
+}
+
+// lifeSpanInternal return the lifespan an item added or updated with the
+// lifespan d gets, cut down to the maximum lifespan, the method is internal
+// and requires the table lock
+func (table *CacheTable) lifeSpanInternal(d time.Duration) (time.Duration, error) {
+	if d < 0 {
+		return 0, ErrInvalidLifeSpan
+	}
+	if table.maxLifeSpan > 0 && (d > table.maxLifeSpan || d == 0 && table.clampZeroLifeSpan) {
+		d = table.maxLifeSpan
+	}
+	return d, nil
+}
+
+// size of the item according to the item sizer, the method is internal and
+// requires the table lock
+func (table *CacheTable) sizeInternal(item *CacheItem) int64 {
+	if table.sizer == nil {
+		return 0
+	}
+	return table.sizer(item)
+}
+
+// size a stored item gets when its data is replaced by data, the method is
+// internal and requires the table lock. Like for added items, ErrItemTooLarge
+// or ErrCacheFull is returned if the new data doesn't fit the byte budget
+func (table *CacheTable) resizeInternal(item *CacheItem, data interface{}) (int64, error) {
+	if table.sizer == nil {
+		return 0, nil
+	}
+	item.RLock()
+	probe := &CacheItem{
+		key:         item.key,
+		data:        data,
+		lifeSpan:    item.lifeSpan,
+		mode:        item.mode,
+		expireAt:    item.expireAt,
+		createdOn:   item.createdOn,
+		accessedOn:  item.accessedOn,
+		accessCount: item.accessCount,
+		tags:        item.tags,
+		pinned:      item.pinned,
+		meta:        item.meta,
+		clock:       item.clock,
+		queueIndex:  -1,
+	}
+	item.RUnlock()
+
+	size := table.sizeInternal(probe)
+	if table.maxBytes > 0 && size > table.maxBytes {
+		return 0, ErrItemTooLarge
+	}
+	if !table.fitsInternal(probe, table.overflowPolicy != Reject) {
+		return 0, ErrCacheFull
+	}
+	return size, nil
+}
+
+// evict items according to the overflow policy until the table is within its
+// capacity and byte budget, the method is internal and requires the table
+// lock. The item keep is never evicted
+func (table *CacheTable) evictInternal(keep *CacheItem) {
+	if table.overflowPolicy == Reject {
+		return
+	}
+	if table.evictionSampleSize > 0 {
+		for table.overflowInternal() {
+			victim := table.victimInternal(keep)
+			if victim == nil {
+				return
+			}
+			if _, err := table.deleteInternal(victim.key, removedEvicted); err == nil {
+				table.stats.evictions.Add(1)
+			}
+		}
+		return
+	}
+
+	// rank all candidates once, so evicting many items doesn't scan the table
+	// per victim
+	var victims *victimHeap
+	for table.overflowInternal() {
+		if victims == nil {
+			victims = table.victimsInternal(keep)
+		}
+		if victims.Len() == 0 {
+			return
+		}
+		victim := heap.Pop(victims).(victimCandidate).item
+		// the table is unlocked while deleting, so the candidate may be gone
+		if table.items[victim.key] != victim || victim.removing || !table.evictableInternal(victim) {
+			continue
+		}
+		if _, err := table.deleteInternal(victim.key, removedEvicted); err == nil {
+			table.stats.evictions.Add(1)
+		}
+	}
+}
+
+// reports whether an item expiring after d is due before the armed expiration
+// check, the method is internal and requires the table lock
+func (table *CacheTable) imminentInternal(d time.Duration) bool {
+	if table.lazyExpiration {
+		return false
+	}
+	if d < table.minCleanupInterval {
+		d = table.minCleanupInterval
+	}
+	return table.cleanupInterval == 0 || d < table.cleanupInterval
+}
+
+// reports whether key can be used as a map key, e.g. slices can't
+func validKey(key interface{}) bool {
+	return key == nil || reflect.ValueOf(key).Comparable()
+}
+
+// add item to the cache, the method is internal. If write is set, the item is
+// written through to the backing store
+func (table *CacheTable) addInternal(item *CacheItem, write bool) error {
+	table.jitterInternal(item)
+	if err := table.admitInternal(item, write); err != nil {
+		table.Unlock()
+		return err
+	}
+	table.logItem("item added", item, "Adding item with key", item.key, "and lifespan of", item.lifeSpan, "to table", table.name)
+	table.insertInternal(item)
+	table.emitInternal(EventAdded, item.key)
+	table.evictInternal(item)
+
+	// cache value so we don't keep blocking the mutex
+	check := item.lifeSpan > 0 && table.imminentInternal(item.lifeSpan)
+	addedItem := table.addedItem
+	table.Unlock()
+
+	// Trigger callbacks after adding the item to cache
+	for _, callback := range addedItem {
+		table.safeCall(func() { callback(item) })
+	}
+
+	// If we haven't set up any expiration check timer or found a more imminent item
+	if check {
+		table.expirationCheck()
+	}
+
+	return nil
+}
+
+// randomize the lifespan of a new item according to the TTL jitter, the method
+// is internal and requires the table lock
+func (table *CacheTable) jitterInternal(item *CacheItem) {
+	if item.lifeSpan > 0 && table.ttlJitter > 0 {
+		item.lifeSpan += time.Duration((rand.Float64()*2 - 1) * table.ttlJitter * float64(item.lifeSpan))
+	}
+}
+
+// check whether item can be added to the table and write it through to the
+// backing store if write is set, the method is internal and requires the table
+// lock. The lifespan of the item is cut down to the maximum lifespan
+func (table *CacheTable) admitInternal(item *CacheItem, write bool) error {
+	lifeSpan, err := table.lifeSpanInternal(item.lifeSpan)
+	if err != nil {
+		return err
+	}
+	item.lifeSpan = lifeSpan
+	if item.key == nil {
+		return ErrNilKey
+	}
+	if !validKey(item.key) {
+		return ErrInvalidKey
+	}
+	if table.maxBytes > 0 && table.sizeInternal(item) > table.maxBytes {
+		return ErrItemTooLarge
+	}
+	if !table.fitsInternal(item, table.overflowPolicy != Reject) {
+		return ErrCacheFull
+	}
+	if write {
+		return table.storeWriteInternal(item.key, item.data)
+	}
+	return nil
+}
+
+// Add adds a key/value pair to the cache. The item is returned even if it
+// could not be added, the failure is logged. Use AddChecked to find out about
+// failures
+func (table *CacheTable) Add(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
+	item, err := table.add(key, lifeSpan, data)
+	if err != nil {
+		table.logAddFailed(key, err)
+	}
+
+	return item
+}
+
+// AddChecked adds a key/value pair to the cache like Add, but reports why the
+// item could not be added, e.g. ErrNilKey, ErrInvalidKey, ErrInvalidLifeSpan,
+// ErrItemTooLarge, ErrCacheFull or an error of the write-through callback.
+// nil is returned along with the error
+func (table *CacheTable) AddChecked(key interface{}, lifeSpan time.Duration, data interface{}) (*CacheItem, error) {
+	item, err := table.add(key, lifeSpan, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// add a new item, the method is internal. The item is returned along with the
+// error if it could not be added
+func (table *CacheTable) add(key interface{}, lifeSpan time.Duration, data interface{}) (*CacheItem, error) {
+	key = table.normalize(key)
+	table.RLock()
+	item := newCacheItem(table.clock, key, lifeSpan, data)
+	table.RUnlock()
+
+	if key == nil {
+		return item, ErrNilKey
+	}
+	if !validKey(key) {
+		return item, ErrInvalidKey
+	}
+	if lifeSpan < 0 {
+		return item, ErrInvalidLifeSpan
+	}
+
+	// Add item to the cache
+	table.Lock()
+	return item, table.addInternal(item, true)
+}
+
+// log why an item could not be added, the table must not be locked
+func (table *CacheTable) logAddFailed(key interface{}, err error) {
+	table.RLock()
+	defer table.RUnlock()
+	table.log("Failed adding item with key", key, "to table", table.name+":", err)
+}
+
+// AddDefault adds a key/value pair to the cache using the table's default
+// lifespan
+func (table *CacheTable) AddDefault(key interface{}, data interface{}) *CacheItem {
+	table.RLock()
+	lifeSpan := table.defaultLifeSpan
+	table.RUnlock()
+
+	return table.Add(key, lifeSpan, data)
+}
+
+// AddWithTags adds a key/value pair to the cache, tagged with the given tags.
+// All items carrying a tag can be removed at once with DeleteByTag
+func (table *CacheTable) AddWithTags(key interface{}, lifeSpan time.Duration, data interface{}, tags ...string) *CacheItem {
+	key = table.normalize(key)
+	// Add item to the cache
+	table.Lock()
+	item := newCacheItem(table.clock, key, lifeSpan, data)
+	item.tags = tags
+	if err := table.addInternal(item, true); err != nil {
+		table.logAddFailed(key, err)
+	}
+
+	return item
+}
+
+// AddWithExpirationMode adds a key/value pair to the cache like Add, with
+// the lifespan measured according to mode
+func (table *CacheTable) AddWithExpirationMode(key interface{}, lifeSpan time.Duration, data interface{}, mode ExpirationMode) *CacheItem {
+	key = table.normalize(key)
+	table.Lock()
+	item := newCacheItem(table.clock, key, lifeSpan, data)
+	item.mode = mode
+	if err := table.addInternal(item, true); err != nil {
+		table.logAddFailed(key, err)
+	}
+
+	return item
+}
+
+// AddBatch adds multiple items to the cache while taking the table lock only
+// once. The expiration check runs at most once, for the item in the batch
+// closest to its end-of-lifespan. Items which can't be added, e.g. nil items,
+// items with an invalid key or items not fitting into the table, are skipped
+func (table *CacheTable) AddBatch(items []*CacheItem) {
+	table.addBatch(items, true)
+}
+
+// add multiple items under a single lock and return how many of them were
+// stored, the method is internal. If write is set, the items are new writes:
+// their lifespan is jittered and they are written through to the backing
+// store. Otherwise they are restored as they were saved
+func (table *CacheTable) addBatch(items []*CacheItem, write bool) int {
+	table.Lock()
+	now := table.clock.Now()
+	smallestDuration := 0 * time.Second
+	added := make([]*CacheItem, 0, len(items))
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		item.key = table.normalizeInternal(item.key)
+		if write {
+			table.jitterInternal(item)
+		}
+		if err := table.admitInternal(item, write); err != nil {
+			table.log("Failed adding item with key", item.key, "to table", table.name+":", err)
+			continue
+		}
+		added = append(added, item)
+		table.logItem("item added", item, "Adding item with key", item.key, "and lifespan of", item.lifeSpan, "to table", table.name)
+		table.insertInternal(item)
+		table.emitInternal(EventAdded, item.key)
+		table.evictInternal(item)
+		if item.lifeSpan == 0 {
+			continue
+		}
+		if remaining := item.expiresInternal().Sub(now); smallestDuration == 0 || remaining < smallestDuration {
+			smallestDuration = remaining
+		}
+	}
+
+	// items may have been evicted or replaced by later items of the batch
+	stored := 0
+	for _, item := range added {
+		if table.items[item.key] == item {
+			stored++
+		}
+	}
+
+	// cache value so we don't keep blocking the mutex
+	check := smallestDuration != 0 && table.imminentInternal(smallestDuration)
+	addedItem := table.addedItem
+	table.Unlock()
+
+	// Trigger callbacks after adding the items to cache
+	for _, item := range added {
+		for _, callback := range addedItem {
+			table.safeCall(func() { callback(item) })
+		}
+	}
+
+	if check {
+		table.expirationCheck()
+	}
+
+	return stored
+}
+
+// streamBatchSize is the maximum number of items AddStream adds per lock
+const streamBatchSize = 256
+
+// AddStream adds the items received from ch until it's closed and returns how
+// many were stored. Items which are ready are added in batches like AddBatch,
+// and the table lock is released between the batches, so the table stays
+// usable while a producer e.g. reads the items from a database. Items which
+// can't be added are skipped like with AddBatch
+func (table *CacheTable) AddStream(ch <-chan *CacheItem) int {
+	added := 0
+	batch := make([]*CacheItem, 0, streamBatchSize)
+	for item := range ch {
+		batch = append(batch, item)
+	fill:
+		for len(batch) < streamBatchSize {
+			select {
+			case item, ok := <-ch:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, item)
+			default:
+				break fill
+			}
+		}
+		added += table.addBatch(batch, true)
+		batch = batch[:0]
+	}
+
+	return added
+}
+
+// why an item gets removed from the table
+type removeReason int
+
+const (
+	removedManually removeReason = iota
+	removedExpired
+	removedEvicted
+)
+
+// EvictReason tells the evicted-item callback why an item was removed
+type EvictReason int
+
+const (
+	// EvictManual items were deleted
+	EvictManual EvictReason = iota
+	// EvictExpired items ran out of lifespan
+	EvictExpired
+	// EvictCapacity items were evicted to make room for other items
+	EvictCapacity
+)
+
+// String return the name of the reason
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	default:
+		return "manual"
+	}
+}
+
+// evictReason return the reason reported to the evicted-item callback
+func (r removeReason) evictReason() EvictReason {
+	switch r {
+	case removedExpired:
+		return EvictExpired
+	case removedEvicted:
+		return EvictCapacity
+	default:
+		return EvictManual
+	}
+}
+
+// String return the log message for the removal
+func (r removeReason) String() string {
+	switch r {
+	case removedExpired:
+		return "item expired"
+	case removedEvicted:
+		return "item evicted"
+	default:
+		return "item deleted"
+	}
+}
+
+// delete item from the cache, the method is internal and requires the table
+// lock. The lock is released while the callbacks run, so they may call back
+// into the table. The item's own about-to-expire callback is only triggered if
+// the item expired
+func (table *CacheTable) deleteInternal(key interface{}, reason removeReason) (*CacheItem, error) {
+	r, ok := table.items[key]
+	if !ok || r.removing {
+		// a concurrent or outer delete of the item is already running its
+		// callbacks, so don't run them twice
+		return nil, ErrKeyNotFound
+	}
+	if reason == removedManually {
+		if err := table.storeDeleteInternal(key); err != nil {
+			return nil, err
+		}
+	}
+	if r.borrows > 0 && reason == removedManually {
+		// hidden right away, but the callbacks wait for the last borrower
+		table.logItem(reason.String(), r, "Deleting borrowed item with key", key, "from table", table.name)
+		table.removeInternal(r)
+		table.emitInternal(EventDeleted, key)
+		r.onRelease = func() { table.notifyDeleted([]*CacheItem{r}, true) }
+		return r, nil
+	}
+	r.removing = true
+	// cache value so we don't keep blocking the mutex
+	aboutToDeleteItem := table.aboutToDeleteItem
+	expiredItem := table.expiredItem
+	evictedItem := table.evictedItem
+	table.Unlock()
+
+	// trigger the callbacks before deleting the item from cache
+	for _, callback := range aboutToDeleteItem {
+		table.safeCall(func() { callback(r) })
+	}
+
+	if reason == removedExpired {
+		if expiredItem != nil {
+			table.safeCall(func() { expiredItem(r) })
+		}
+		r.RLock()
+		aboutToExpire := r.aboutToExpire
+		r.RUnlock()
+		if aboutToExpire != nil {
+			table.safeCall(func() { aboutToExpire(key) })
+		}
+	}
+	if evictedItem != nil {
+		table.safeCall(func() { evictedItem(r, reason.evictReason()) })
+	}
+
+	table.Lock()
+	r.removing = false
+	table.logItem(reason.String(), r, "Deleting item with key", key, "was created on", r.CreatedOn(), "and hit", r.AccessCount(), "times from table", table.name)
+	// the key might have been taken over by a new item while unlocked
+	if table.items[key] == r {
+		table.removeInternal(r)
+		switch reason {
+		case removedExpired:
+			table.emitInternal(EventExpired, key)
+		case removedEvicted:
+			table.emitInternal(EventEvicted, key)
+		default:
+			table.emitInternal(EventDeleted, key)
+		}
+	}
+
+	return r, nil
+}
+
+// Delete item from the cache, the method is exported
+func (table *CacheTable) Delete(key interface{}) (*CacheItem, error) {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	table.Lock()
+	r, err := table.deleteInternal(key, removedManually)
+	table.Unlock()
+
+	if err == ErrKeyNotFound {
+		return nil, table.keyError(key, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	table.stats.deletions.Add(1)
+
+	return r, nil
+}
+
+// DeleteBatch deletes multiple items from the cache under a single lock and
+// returns how many of them were deleted. ErrKeyNotFound is returned if at least
+// one of the keys did not exist, or the first error of the write-through
+// callback
+func (table *CacheTable) DeleteBatch(keys []interface{}) (int, error) {
+	table.Lock()
+	defer table.Unlock()
+
+	deleted := 0
+	var failed error
+	for _, key := range keys {
+		key = table.normalizeInternal(key)
+		if !validKey(key) {
+			continue
+		}
+		_, err := table.deleteInternal(key, removedManually)
+		if err == nil {
+			table.stats.deletions.Add(1)
+			deleted++
+		} else if err != ErrKeyNotFound && failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return deleted, failed
+	}
+	if deleted < len(keys) {
+		return deleted, ErrKeyNotFound
+	}
+
+	return deleted, nil
+}
+
+// DeleteByTag deletes all items carrying the given tag from the cache and
+// returns how many were deleted
+func (table *CacheTable) DeleteByTag(tag string) int {
+	table.Lock()
+	defer table.Unlock()
+
+	keys := make([]interface{}, 0, len(table.tags[tag]))
+	for key := range table.tags[tag] {
+		keys = append(keys, key)
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		// the key might have been re-added without the tag while unlocked
+		if _, ok := table.tags[tag][key]; !ok {
+			continue
+		}
+		if _, err := table.deleteInternal(key, removedManually); err == nil {
+			table.stats.deletions.Add(1)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// DeleteMatch deletes all items whose key satisfies pred from the cache and
+// returns how many were deleted
+func (table *CacheTable) DeleteMatch(pred func(key interface{}) bool) int {
+	table.Lock()
+	defer table.Unlock()
+
+	var keys []interface{}
+	for key := range table.items {
+		if pred(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		if _, err := table.deleteInternal(key, removedManually); err == nil {
+			table.stats.deletions.Add(1)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// DeletePrefix deletes all items with a string key starting with prefix from
+// the cache and returns how many were deleted. Keys of other types are ignored
+func (table *CacheTable) DeletePrefix(prefix string) int {
+	return table.DeleteMatch(func(key interface{}) bool {
+		s, ok := key.(string)
+		return ok && strings.HasPrefix(s, prefix)
+	})
+}
+
+// DeleteSubtree treats string keys as paths delimited by sep and deletes the
+// item at prefix and all items below it from the cache, e.g. "/tenant/42"
+// matches "/tenant/42" and "/tenant/42/users/7" but not "/tenant/420". Returns
+// how many were deleted
+func (table *CacheTable) DeleteSubtree(prefix string, sep string) int {
+	prefix = strings.TrimSuffix(prefix, sep)
+	return table.DeleteMatch(func(key interface{}) bool {
+		s, ok := key.(string)
+		return ok && (s == prefix || strings.HasPrefix(s, prefix+sep))
+	})
+}
+
+// UpdateEach atomically visits all items and replaces their data by the data
+// fn returns, or deletes them if fn returns false for keep. fn is called with
+// the table locked and must not call back into the table. The about-to-delete
+// callbacks for deleted items are called after the table was unlocked. Items
+// whose new data doesn't fit the byte budget or whose write-through callback
+// fails are left untouched
+func (table *CacheTable) UpdateEach(fn func(key interface{}, item *CacheItem) (newData interface{}, keep bool)) {
+	table.Lock()
+	var removed []*CacheItem
+	for key, item := range table.items {
+		data, keep := fn(key, item)
+		if !keep {
+			if err := table.storeDeleteInternal(key); err != nil {
+				table.log("Failed deleting item with key", key, "from table", table.name+":", err)
+				continue
+			}
+			table.logItem(removedManually.String(), item, "Deleting item with key", key, "from table", table.name)
+			table.removeInternal(item)
+			table.emitInternal(EventDeleted, key)
+			table.stats.deletions.Add(1)
+			if item.borrows > 0 {
+				item.onRelease = func() { table.notifyDeleted([]*CacheItem{item}, true) }
+				continue
+			}
+			removed = append(removed, item)
+			continue
+		}
+
+		stored := table.compressInternal(data)
+		size, err := table.resizeInternal(item, stored)
+		if err == nil {
+			err = table.storeWriteInternal(key, data)
+		}
+		if err != nil {
+			table.log("Failed updating item with key", key, "in table", table.name+":", err)
+			continue
+		}
+		item.Lock()
+		item.data = stored
+		item.Unlock()
+		table.bytes += size - item.size
+		item.size = size
+		table.emitInternal(EventUpdated, key)
+	}
+	// evicting unlocks the table, so it can't run while iterating
+	table.evictInternal(nil)
+	table.Unlock()
+
+	table.notifyDeleted(removed, true)
+}
+
+// run the about-to-delete callbacks for deleted items and, if evicted is set,
+// the evicted-item callback, the table must not be locked by the caller
+func (table *CacheTable) notifyDeleted(items []*CacheItem, evicted bool) {
+	if len(items) == 0 {
+		return
+	}
+	table.RLock()
+	// cache value so we don't keep blocking the mutex
+	aboutToDeleteItem := table.aboutToDeleteItem
+	evictedItem := table.evictedItem
+	table.RUnlock()
+
+	for _, item := range items {
+		for _, callback := range aboutToDeleteItem {
+			table.safeCall(func() { callback(item) })
+		}
+		if evicted && evictedItem != nil {
+			table.safeCall(func() { evictedItem(item, EvictManual) })
+		}
+	}
+}
+
+// UpdateLifeSpan changes the lifespan of the item with the given key and
+// reschedules the expiration check if the item is now more imminent. An item
+// whose new lifespan is shorter than its idle time, or its age for CreationTTL
+// items, expires right away. The lifespan is cut down to the maximum lifespan
+// and ErrInvalidLifeSpan is returned if it's negative
+func (table *CacheTable) UpdateLifeSpan(key interface{}, d time.Duration) error {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	table.Lock()
+	r, ok := table.items[key]
+	if !ok {
+		table.Unlock()
+		return table.keyError(key, ErrKeyNotFound)
+	}
+	d, err := table.lifeSpanInternal(d)
+	if err != nil {
+		table.Unlock()
+		return err
+	}
+	r.SetLifeSpan(d)
+	table.scheduleInternal(r)
+	r.RLock()
+	expires := r.expiresInternal()
+	r.RUnlock()
+	check := d > 0 && table.imminentInternal(expires.Sub(table.clock.Now()))
+	table.Unlock()
+
+	if check {
+		table.expirationCheck()
+	}
+
+	return nil
+}
+
+// ExpireAt sets a hard deadline for the item with the given key. The item
+// still expires when it's idle for its lifespan, but keeping it alive can't
+// extend its life beyond the deadline. Also applies to items which never
+// expire otherwise, the zero time removes the deadline
+func (table *CacheTable) ExpireAt(key interface{}, t time.Time) error {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	table.Lock()
+	r, ok := table.items[key]
+	if !ok {
+		table.Unlock()
+		return table.keyError(key, ErrKeyNotFound)
+	}
+	r.Lock()
+	r.expireAt = t
+	expiring := r.expiringInternal()
+	expires := r.expiresInternal()
+	r.Unlock()
+	table.scheduleInternal(r)
+	check := expiring && table.imminentInternal(expires.Sub(table.clock.Now()))
+	table.Unlock()
+
+	if check {
+		table.expirationCheck()
+	}
+
+	return nil
+}
+
+// Exists returns if an item exists in the cache but doesn't
+// try to fetch data via the loadData callback
+func (table *CacheTable) Exists(key interface{}) bool {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return false
+	}
+	table.RLock()
+	r, ok := table.items[key]
+	lazy := table.lazyExpiration
+	table.RUnlock()
+
+	return ok && !(lazy && table.expireOnRead(key, r))
+}
+
+// NotFoundAdd tests whether an item not found in the cache. Unlike the Exists
+// method this also adds data if the key could not be found.
+func (table *CacheTable) NotFoundAdd(key interface{}, lifeSpan time.Duration, data interface{}) bool {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return false
+	}
+	table.Lock()
+
+	if _, ok := table.items[key]; ok {
+		table.Unlock()
+		return false
+	}
+
+	item := newCacheItem(table.clock, key, lifeSpan, data)
+
+	return table.addInternal(item, true) == nil
+}
+
+// GetOrAdd atomically returns the existing item for the given key and marks it
+// to be kept alive, or adds a new item if the key could not be found. The
+// second return value reports whether the item was newly added. Like with
+// Add, a new item which could not be added is returned anyway and the failure
+// is logged
+func (table *CacheTable) GetOrAdd(key interface{}, lifeSpan time.Duration, data interface{}) (*CacheItem, bool) {
+	r, loaded, err := table.addIfAbsent(key, lifeSpan, data, true)
+	if err != nil {
+		table.logAddFailed(key, err)
+	}
+	return r, !loaded && err == nil
+}
+
+// LoadOrStore works like sync.Map.LoadOrStore: it atomically returns the
+// existing item for the given key and marks it to be kept alive, or stores a
+// new item if the key could not be found. The data-loader is never called.
+// loaded reports whether actual is an existing item. If the new item could not
+// be stored, e.g. because the key is invalid or the table is full, actual is
+// nil
+func (table *CacheTable) LoadOrStore(key interface{}, lifeSpan time.Duration, data interface{}) (actual *CacheItem, loaded bool) {
+	actual, loaded, err := table.addIfAbsent(key, lifeSpan, data, true)
+	if err != nil {
+		table.logAddFailed(key, err)
+		return nil, false
+	}
+	return actual, loaded
+}
+
+// AddIfAbsent atomically adds a new item if the key could not be found, or
+// returns the existing item otherwise. Unlike GetOrAdd an existing item is not
+// marked to be kept alive. The second return value reports whether the item
+// was newly added. Like with Add, a new item which could not be added is
+// returned anyway and the failure is logged
+func (table *CacheTable) AddIfAbsent(key interface{}, lifeSpan time.Duration, data interface{}) (*CacheItem, bool) {
+	r, loaded, err := table.addIfAbsent(key, lifeSpan, data, false)
+	if err != nil {
+		table.logAddFailed(key, err)
+	}
+	return r, !loaded && err == nil
+}
+
+// add a new item unless the key exists, the method is internal. It reports
+// whether an existing item was returned. A new item which could not be added
+// is returned along with the error
+func (table *CacheTable) addIfAbsent(key interface{}, lifeSpan time.Duration, data interface{}, keepAlive bool) (*CacheItem, bool, error) {
+	key = table.normalize(key)
+	table.RLock()
+	item := newCacheItem(table.clock, key, lifeSpan, data)
+	table.RUnlock()
+	if !validKey(key) {
+		return item, false, ErrInvalidKey
+	}
+	table.Lock()
+
+	if r, ok := table.items[key]; ok {
+		keepAlive = keepAlive && !table.untracked
+		table.Unlock()
+		if keepAlive {
+			r.KeepAlive()
+		}
+		return r, true, nil
+	}
+
+	return item, false, table.addInternal(item, true)
+}
+
+// Replace updates the data and lifespan of the item with the given key, but
+// never adds a new item. The item keeps its creation time and access count
+// and its lifespan starts over. A KeyError wrapping ErrKeyNotFound is returned
+// if the key does not exist
+func (table *CacheTable) Replace(key interface{}, lifeSpan time.Duration, data interface{}) (*CacheItem, error) {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	table.Lock()
+
+	r, ok := table.items[key]
+	if !ok {
+		table.Unlock()
+		return nil, table.keyError(key, ErrKeyNotFound)
+	}
+	check, err := table.updateInternal(r, data, lifeSpan)
+	table.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if check {
+		table.expirationCheck()
+	}
+
+	return r, nil
+}
+
+// AddOrRefresh adds a key/value pair to the cache like Add, but if the key
+// already exists its data and lifespan are updated in place like Replace, so
+// the item keeps its creation time and access count. Like with Add, failures
+// are logged and the item is returned anyway, an existing item unchanged
+func (table *CacheTable) AddOrRefresh(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return table.Add(key, lifeSpan, data)
+	}
+	table.Lock()
+
+	r, ok := table.items[key]
+	if !ok {
+		item := newCacheItem(table.clock, key, lifeSpan, data)
+		if err := table.addInternal(item, true); err != nil {
+			table.logAddFailed(key, err)
+		}
+		return item
+	}
+	check, err := table.updateInternal(r, data, lifeSpan)
+	table.Unlock()
+	if err != nil {
+		table.logAddFailed(key, err)
+		return r
+	}
+
+	if check {
+		table.expirationCheck()
+	}
+
+	return r
 }
 
-// Foreach all items in the table
-func (table *CacheTable) Foreach(trans func(k interface{}, item *CacheItem)) {
-	table.RLock()
-	defer table.RUnlock()
+// CompareAndSwap atomically replaces the data of the item with the given key
+// by new and sets its lifespan, but only if its current data is deeply equal
+// to old. It reports whether the data was swapped
+func (table *CacheTable) CompareAndSwap(key interface{}, old, new interface{}, lifeSpan time.Duration) (bool, error) {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return false, ErrInvalidKey
+	}
+	table.Lock()
 
-	for k, v := range table.items {
-		trans(k, v)
+	r, ok := table.items[key]
+	if !ok {
+		table.Unlock()
+		return false, table.keyError(key, ErrKeyNotFound)
+	}
+	if !reflect.DeepEqual(r.Data(), old) {
+		table.Unlock()
+		return false, nil
+	}
+	check, err := table.updateInternal(r, new, lifeSpan)
+	table.Unlock()
+	if err != nil {
+		return false, err
 	}
+
+	if check {
+		table.expirationCheck()
+	}
+
+	return true, nil
 }
 
-// SetDataLoader configure a data-loader callback, which will be called when
-// trying to access a non-exisiting key. The key and 0...n additional arguments
-// are passed to the callback function
-func (table *CacheTable) SetDataLoader(f func(interface{}, ...interface{}) *CacheItem) {
+// SwapValue replaces the data of the item with the given key and returns the
+// old data. The item keeps its lifespan, timestamps and access count, so
+// unlike Replace it neither extends its life nor changes its eviction order.
+// Like Replace, other items may be evicted to make room for the new data
+func (table *CacheTable) SwapValue(key interface{}, newData interface{}) (interface{}, error) {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
 	table.Lock()
+	r, ok := table.items[key]
+	if !ok {
+		table.Unlock()
+		return nil, table.keyError(key, ErrKeyNotFound)
+	}
 	defer table.Unlock()
-	table.loadData = f
+
+	stored := table.compressInternal(newData)
+	size, err := table.resizeInternal(r, stored)
+	if err != nil {
+		return nil, err
+	}
+	if err := table.storeWriteInternal(key, newData); err != nil {
+		return nil, err
+	}
+	old := r.Swap(stored)
+	table.bytes += size - r.size
+	r.size = size
+	table.emitInternal(EventUpdated, key)
+	table.evictInternal(r)
+
+	return old, nil
 }
 
-// SetAddedItemCallback configure a callback, which will be called when
-// a new item is added to the cache
-func (table *CacheTable) SetAddedItemCallback(f func(item *CacheItem)) {
+// Increment atomically adds delta to the int64 stored under the given key and
+// returns the new value. If the key does not exist, a new item holding delta
+// is added with the given lifespan
+func (table *CacheTable) Increment(key interface{}, delta int64, lifeSpan time.Duration) (int64, error) {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return 0, ErrInvalidKey
+	}
 	table.Lock()
+
+	r, ok := table.items[key]
+	if !ok {
+		if err := table.addInternal(newCacheItem(table.clock, key, lifeSpan, delta), true); err != nil {
+			return 0, err
+		}
+		return delta, nil
+	}
 	defer table.Unlock()
-	table.addedItem = f
+
+	r.Lock()
+	defer r.Unlock()
+	v, ok := r.data.(int64)
+	if !ok {
+		return 0, ErrNotInt64
+	}
+	v += delta
+	if err := table.storeWriteInternal(key, v); err != nil {
+		return 0, err
+	}
+	r.data = v
+	r.accessedOn = table.clock.Now()
+	r.accessCount++
+	table.emitInternal(EventUpdated, key)
+
+	return v, nil
 }
 
-// SetAboutToDeleteItemCallback configures a callback, which will be called
-// every time an item is about to removed from the cache
-func (table *CacheTable) SetAboutToDeleteItemCallback(f func(item *CacheItem)) {
-	table.Lock()
-	defer table.Unlock()
-	table.aboutToDeleteItem = f
+// Decrement atomically subtracts delta from the int64 stored under the given key
+func (table *CacheTable) Decrement(key interface{}, delta int64, lifeSpan time.Duration) (int64, error) {
+	return table.Increment(key, -delta, lifeSpan)
 }
 
-// SetLogger configure the logger used by the table
-func (table *CacheTable) SetLogger(logger *log.Logger) {
-	table.Lock()
-	defer table.Unlock()
-	table.logger = logger
+// Value returns an item from the cache and marks it to be kept alive.
+// You can pass additional arguments to your Dataloader callback function.
+func (table *CacheTable) Value(key interface{}, args ...interface{}) (*CacheItem, error) {
+	return table.ValueContext(context.Background(), key, args...)
 }
 
-// expiration check loop, triggered by a self-adjusting timer
-func (table *CacheTable) expirationCheck() {
-	table.Lock()
-	if table.cleanupTimer != nil {
-		table.cleanupTimer.Stop()
-	}
-	if table.cleanupInterval > 0 {
-		table.log("Expiration check trigged after", table.cleanupInterval, "for table", table.name)
-	} else {
-		table.log("Expiration check installed for table", table.name)
+// ValueContext works like Value but passes ctx on to the data-loader callback.
+// If ctx is done before the data-loader returns, ctx.Err() is returned and
+// nothing is added to the cache
+func (table *CacheTable) ValueContext(ctx context.Context, key interface{}, args ...interface{}) (*CacheItem, error) {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return nil, ErrInvalidKey
 	}
+	table.RLock()
+	r, ok := table.items[key]
+	loadData := table.loadData
+	refreshAhead := table.refreshAhead
+	renewThreshold := table.renewThreshold
+	staleWindow := table.staleWindow
+	untracked := table.untracked
+	lazy := table.lazyExpiration
+	now := table.clock.Now()
+	table.RUnlock()
 
-	now := time.Now()
-	smallestDuration := 0 * time.Second
-	for key, item := range table.items {
-		item.RLock()
-		lifeSpan := item.lifeSpan
-		accessedOn := item.accessedOn
-		item.RUnlock()
-
-		if lifeSpan == 0 {
-			continue
+	if ok && lazy && table.expireOnRead(key, r) {
+		ok = false
+	}
+	if ok && staleWindow > 0 {
+		r.RLock()
+		stale := r.expiringInternal() && !now.Before(r.expiresInternal())
+		r.RUnlock()
+		if stale {
+			// serve the expired item while it's reloaded, without keeping
+			// it alive
+			if loadData != nil {
+				table.refresh(key, loadData, args...)
+			}
+			table.stats.hits.Add(1)
+			return r, nil
 		}
-		if now.Sub(accessedOn) >= lifeSpan {
-			// item has exceeded its lifespan
-			table.deleteInternal(key)
-		} else {
-			// find the item chronologically closest to its end-of-lifespan
-			if smallestDuration == 0 || lifeSpan-now.Sub(accessedOn) < smallestDuration {
-				smallestDuration = lifeSpan - now.Sub(accessedOn)
+	}
+	if ok {
+		renew := true
+		if refreshAhead > 0 || renewThreshold > 0 {
+			r.RLock()
+			lifeSpan := r.lifeSpan
+			remaining := r.expiresInternal().Sub(now)
+			r.RUnlock()
+			if refreshAhead > 0 && loadData != nil && lifeSpan > 0 && remaining < time.Duration(float64(lifeSpan)*refreshAhead) {
+				table.refresh(key, loadData, args...)
 			}
+			renew = renewThreshold <= 0 || lifeSpan == 0 || remaining < time.Duration(float64(lifeSpan)*renewThreshold)
+		}
+		if renew && !untracked {
+			// update access counter and timestamp
+			r.KeepAlive()
 		}
+		table.stats.hits.Add(1)
+		return r, nil
 	}
+	table.stats.misses.Add(1)
 
-	// setup the interval for the next cleanup check
-	table.cleanupInterval = smallestDuration
-	if smallestDuration > 0 {
-		table.cleanupTimer = time.AfterFunc(smallestDuration, func() {
-			go table.expirationCheck()
-		})
+	// item doesn't exist in the cache. Try and fetch it with a data-loader
+	// unless it is known to be absent
+	if loadData != nil {
+		if !table.isNegative(key) {
+			r, err := table.load(ctx, key, loadData, args...)
+			if err != ErrKeyNotFoundOrLoadable {
+				return r, err
+			}
+		}
+		return table.fallbackValue(key)
 	}
-	table.Unlock()
-}
 
-// add item to the cache, the method is internal
-func (table *CacheTable) addInternal(item *CacheItem) {
-	table.log("Adding item with key", item.key, "and lifespan of", item.lifeSpan, "to table", table.name)
-	table.items[item.key] = item
+	return nil, table.keyError(key, ErrKeyNotFound)
+}
 
-	// cache value so we don't keep blocking the mutex
-	expDur := table.cleanupInterval
-	addedItem := table.addedItem
-	table.Unlock()
+// look up a key the data-loader could not load in the fallback table
+func (table *CacheTable) fallbackValue(key interface{}) (*CacheItem, error) {
+	table.RLock()
+	fallback := table.fallback
+	table.RUnlock()
 
-	// Trigger callback after adding the item to cache
-	if addedItem != nil {
-		addedItem(item)
+	if fallback != nil {
+		if r, ok := fallback.Get(key); ok {
+			return r, ErrStale
+		}
 	}
 
-	// If we haven't set up any expiration check timer or found a more imminent item
-	if item.lifeSpan > 0 && (expDur == 0 || item.lifeSpan < expDur) {
-		table.expirationCheck()
+	return nil, table.keyError(key, ErrKeyNotFoundOrLoadable)
+}
+
+// ValueWithTTL works like Value and additionally returns the remaining life
+// of the item, see CacheItem.RemainingLife
+func (table *CacheTable) ValueWithTTL(key interface{}, args ...interface{}) (*CacheItem, time.Duration, error) {
+	r, err := table.Value(key, args...)
+	if err != nil {
+		return nil, 0, err
 	}
+
+	return r, r.RemainingLife(), nil
 }
 
-// Add adds a key/value pair to the cache
-func (table *CacheTable) Add(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
-	item := NewCacheItem(key, lifeSpan, data)
-	// Add item to the cache
-	table.Lock()
-	table.addInternal(item)
+// ValueMany looks up multiple keys like Value and returns the found items by
+// key. Missing keys are loaded with the batch data-loader in a single call,
+// or else with the data-loader one by one. If some keys could neither be found
+// nor loaded, the items found are returned along with ErrKeyNotFound, or
+// ErrKeyNotFoundOrLoadable if a data-loader is configured
+func (table *CacheTable) ValueMany(keys []interface{}, args ...interface{}) (map[interface{}]*CacheItem, error) {
+	found := make(map[interface{}]*CacheItem, len(keys))
+	var missing []interface{}
+	invalid := false
+	// keys may be passed more than once
+	distinct := make(map[interface{}]struct{}, len(keys))
 
-	return item
+	table.RLock()
+	for _, key := range keys {
+		key = table.normalizeInternal(key)
+		if !validKey(key) {
+			invalid = true
+			continue
+		}
+		if _, ok := distinct[key]; ok {
+			continue
+		}
+		distinct[key] = struct{}{}
+		if r, ok := table.items[key]; ok {
+			found[key] = r
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	loadData := table.loadData
+	loadBatch := table.loadBatch
+	untracked := table.untracked
+	table.RUnlock()
+
+	if !untracked {
+		for _, r := range found {
+			r.KeepAlive()
+		}
+	}
+	table.stats.hits.Add(int64(len(found)))
+	table.stats.misses.Add(int64(len(missing)))
+	if len(missing) == 0 && !invalid {
+		return found, nil
+	}
+	if loadData == nil && loadBatch == nil {
+		return found, ErrKeyNotFound
+	}
+
+	var loadable []interface{}
+	for _, key := range missing {
+		if !table.isNegative(key) {
+			loadable = append(loadable, key)
+		}
+	}
+	if loadBatch != nil {
+		if len(loadable) > 0 {
+			for key, r := range table.loadBatchInternal(loadable, loadBatch) {
+				found[key] = r
+			}
+		}
+	} else {
+		for _, key := range loadable {
+			if r, err := table.load(context.Background(), key, loadData, args...); err == nil {
+				found[key] = r
+			}
+		}
+	}
+	if invalid || len(found) < len(distinct) {
+		return found, ErrKeyNotFoundOrLoadable
+	}
+
+	return found, nil
 }
 
-// delete item from the cache, the method is internal
-func (table *CacheTable) deleteInternal(key interface{}) (*CacheItem, error) {
+// Borrow returns an item from the cache like Get and protects it from being
+// evicted until release is called. Deleting a borrowed item, also by
+// UpdateEach or FlushWithCallbacks, removes it from the table right away, but
+// its delete callbacks are only called once the last borrower released it.
+// A borrowed item can still expire
+func (table *CacheTable) Borrow(key interface{}) (*CacheItem, func(), bool) {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return nil, nil, false
+	}
+	table.Lock()
 	r, ok := table.items[key]
 	if !ok {
-		return nil, ErrKeyNotFound
+		table.Unlock()
+		table.stats.misses.Add(1)
+		return nil, nil, false
 	}
-	// cache value so we don't keep blocking the mutex
-	aboutToDeleteItem := table.aboutToDeleteItem
+	r.borrows++
+	untracked := table.untracked
 	table.Unlock()
 
-	// trigger the callback before deleting the item from cache
-	if aboutToDeleteItem != nil {
-		aboutToDeleteItem(r)
+	if !untracked {
+		r.KeepAlive()
 	}
+	table.stats.hits.Add(1)
 
-	r.RLock()
-	defer r.RUnlock()
-	if r.aboutToExpire != nil {
-		r.aboutToExpire(key)
-	}
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			table.Lock()
+			r.borrows--
+			var onRelease func()
+			if r.borrows == 0 {
+				onRelease, r.onRelease = r.onRelease, nil
+			}
+			table.Unlock()
 
-	table.Lock()
-	table.log("Deleting item with key", key, "was created on", r.CreatedOn(), "and hit", r.AccessCount(), "times from table", table.name)
-	delete(table.items, key)
+			if onRelease != nil {
+				onRelease()
+			}
+		})
+	}
 
-	return r, nil
+	return r, release, true
 }
 
-// Delete item from the cache, the method is exported
-func (table *CacheTable) Delete(key interface{}) (*CacheItem, error) {
-	table.Lock()
-	defer table.Unlock()
+// Get returns an item from the cache and marks it to be kept alive. Unlike
+// Value it never calls the data-loader callback on a miss
+func (table *CacheTable) Get(key interface{}) (*CacheItem, bool) {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return nil, false
+	}
+	table.RLock()
+	r, ok := table.items[key]
+	lazy := table.lazyExpiration
+	untracked := table.untracked
+	staleWindow := table.staleWindow
+	table.RUnlock()
+
+	if !ok || (lazy && table.expireOnRead(key, r)) {
+		table.stats.misses.Add(1)
+		return nil, false
+	}
+	if !untracked {
+		r.keepAliveFresh(staleWindow)
+	}
+	table.stats.hits.Add(1)
 
-	return table.deleteInternal(key)
+	return r, true
 }
 
-// Exists returns if an item exists in the cache but doesn't
-// try to fetch data via the loadData callback
-func (table *CacheTable) Exists(key interface{}) bool {
+// Peek returns an item from the cache without marking it to be kept alive and
+// without calling the data-loader. Access times, access counters and the
+// hit/miss statistics are left untouched
+func (table *CacheTable) Peek(key interface{}) (*CacheItem, bool) {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return nil, false
+	}
 	table.RLock()
 	defer table.RUnlock()
-	_, ok := table.items[key]
+	r, ok := table.items[key]
 
-	return ok
+	return r, ok
 }
 
-// NotFoundAdd tests whether an item not found in the cache. Unlike the Exists
-// method this also adds data if the key could not be found.
-func (table *CacheTable) NotFoundAdd(key interface{}, lifeSpan time.Duration, data interface{}) bool {
-	table.Lock()
+// GetMany looks up multiple keys under a single lock without calling the
+// data-loader. Found items are marked to be kept alive and returned by key,
+// the keys which could not be found are returned separately
+func (table *CacheTable) GetMany(keys []interface{}) (map[interface{}]*CacheItem, []interface{}) {
+	table.RLock()
+	defer table.RUnlock()
 
-	if _, ok := table.items[key]; ok {
-		table.Unlock()
-		return false
+	found := make(map[interface{}]*CacheItem, len(keys))
+	var missing []interface{}
+	for _, key := range keys {
+		key = table.normalizeInternal(key)
+		if !validKey(key) {
+			missing = append(missing, key)
+			continue
+		}
+		r, ok := table.items[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		if !table.untracked {
+			r.keepAliveFresh(table.staleWindow)
+		}
+		found[key] = r
 	}
+	table.stats.hits.Add(int64(len(found)))
+	table.stats.misses.Add(int64(len(missing)))
 
-	item := NewCacheItem(key, lifeSpan, data)
-	table.addInternal(item)
-
-	return true
+	return found, missing
 }
 
-// Value returns an item from the cache and marks it to be kept alive.
-// You can pass additional arguments to your Dataloader callback function.
-func (table *CacheTable) Value(key interface{}, args ...interface{}) (*CacheItem, error) {
+// Touch marks the item with the given key to be kept alive without
+// retrieving it. Unlike Value it never calls the data-loader callback
+func (table *CacheTable) Touch(key interface{}) error {
+	key = table.normalize(key)
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
 	table.RLock()
 	r, ok := table.items[key]
-	loadData := table.loadData
+	staleWindow := table.staleWindow
 	table.RUnlock()
 
-	if ok {
-		// update access counter and timestamp
-		r.KeepAlive()
-		return r, nil
+	if !ok {
+		return table.keyError(key, ErrKeyNotFound)
 	}
+	r.keepAliveFresh(staleWindow)
 
-	// item doesn't exist in the cache. Try and fetch it with a data-loader
-	if loadData != nil {
-		item := loadData(key, args...)
-		if item != nil {
-			table.Add(key, item.lifeSpan, item.data)
-			return item, nil
+	return nil
+}
+
+// TouchMany marks all existing items with the given keys to be kept alive
+// under a single lock and returns how many of them were found
+func (table *CacheTable) TouchMany(keys []interface{}) int {
+	table.RLock()
+	defer table.RUnlock()
+
+	touched := 0
+	for _, key := range keys {
+		key = table.normalizeInternal(key)
+		if !validKey(key) {
+			continue
+		}
+		if r, ok := table.items[key]; ok {
+			r.keepAliveFresh(table.staleWindow)
+			touched++
 		}
-		return nil, ErrKeyNotFoundOrLoadable
 	}
 
-	return nil, ErrKeyNotFound
+	return touched
 }
 
-// Flush deletes all items in cache
+// Flush deletes all items in cache. Event subscribers and watchers get an
+// EventDeleted for every flushed key
 func (table *CacheTable) Flush() {
 	table.Lock()
 	defer table.Unlock()
 
+	table.flushInternal()
+}
+
+// FlushWithCallbacks deletes all items in cache like Flush, then calls the
+// about-to-delete callbacks for every item that was flushed. Slower than
+// Flush for big tables, as it has to visit each item
+func (table *CacheTable) FlushWithCallbacks() {
+	table.Lock()
+	items := make([]*CacheItem, 0, len(table.items))
+	for _, item := range table.items {
+		if item.borrows > 0 {
+			item.onRelease = func() { table.notifyDeleted([]*CacheItem{item}, false) }
+			continue
+		}
+		items = append(items, item)
+	}
+	table.flushInternal()
+	table.Unlock()
+
+	table.notifyDeleted(items, false)
+}
+
+// empty the table, the method is internal and requires the table lock
+func (table *CacheTable) flushInternal() {
 	table.log("Flushing table", table.name)
+	if len(table.subscribers) > 0 || len(table.watchers) > 0 {
+		for key := range table.items {
+			table.emitInternal(EventDeleted, key)
+		}
+	}
 	table.items = make(map[interface{}]*CacheItem)
+	if table.order != nil {
+		table.order.Init()
+		table.orderIndex = make(map[interface{}]*list.Element)
+	}
+	table.queue = nil
+	table.tags = make(map[string]map[interface{}]struct{})
+	table.negative = make(map[interface{}]time.Time)
+	table.bytes = 0
 	table.cleanupInterval = 0
+	table.nextCleanup = time.Time{}
 	if table.cleanupTimer != nil {
 		table.cleanupTimer.Stop()
 	}
@@ -262,6 +2414,7 @@ func (table *CacheTable) Flush() {
 type CacheItemPair struct {
 	Key         interface{}
 	AccessCount int64
+	AccessedOn  time.Time
 }
 
 // CacheItemPairList is a slice of CacheItemPairs that implements sort
@@ -283,33 +2436,110 @@ func (p CacheItemPairList) Less(i, j int) bool {
 	return p[i].AccessCount > p[j].AccessCount
 }
 
-// MostAccessed returns the most accessed item in this cache table
+// accessHeap is a min-heap of CacheItemPairs by AccessCount, it implements
+// heap.Interface
+type accessHeap []CacheItemPair
+
+// Len method for accessHeap
+func (h accessHeap) Len() int {
+	return len(h)
+}
+
+// Less method for accessHeap
+func (h accessHeap) Less(i, j int) bool {
+	return h[i].AccessCount < h[j].AccessCount
+}
+
+// Swap method for accessHeap
+func (h accessHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+// Push method for accessHeap
+func (h *accessHeap) Push(x interface{}) {
+	*h = append(*h, x.(CacheItemPair))
+}
+
+// Pop method for accessHeap
+func (h *accessHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	pair := old[n-1]
+	*h = old[:n-1]
+	return pair
+}
+
+// MostAccessed returns the most accessed item in this cache table.
+// Only the top count items are kept in a bounded heap while scanning, so
+// this costs O(n log count) instead of sorting the whole table
 func (table *CacheTable) MostAccessed(count int64) []*CacheItem {
 	table.RLock()
 	defer table.RUnlock()
 
-	p := make(CacheItemPairList, len(table.items))
-	i := 0
+	if count <= 0 || len(table.items) == 0 {
+		return nil
+	}
+	n := len(table.items)
+	if count < int64(n) {
+		n = int(count)
+	}
+
+	h := make(accessHeap, 0, n)
 	for k, v := range table.items {
-		p[i] = CacheItemPair{
-			k,
-			v.accessCount,
+		v.RLock()
+		pair := CacheItemPair{
+			Key:         k,
+			AccessCount: v.accessCount,
+			AccessedOn:  v.accessedOn,
+		}
+		v.RUnlock()
+
+		if len(h) < n {
+			heap.Push(&h, pair)
+		} else if pair.AccessCount > h[0].AccessCount {
+			// replace the least accessed of the top items
+			h[0] = pair
+			heap.Fix(&h, 0)
 		}
-		i++
 	}
-	sort.Sort(p)
+
+	r := make([]*CacheItem, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		r[i] = table.items[heap.Pop(&h).(CacheItemPair).Key]
+	}
+
+	return r
+}
+
+// LeastAccessed returns the least accessed items in this cache table, items
+// with the same access count are ordered from the longest idle one
+func (table *CacheTable) LeastAccessed(count int64) []*CacheItem {
+	table.RLock()
+	defer table.RUnlock()
+
+	p := make(CacheItemPairList, 0, len(table.items))
+	for k, v := range table.items {
+		v.RLock()
+		p = append(p, CacheItemPair{
+			Key:         k,
+			AccessCount: v.accessCount,
+			AccessedOn:  v.accessedOn,
+		})
+		v.RUnlock()
+	}
+	sort.Slice(p, func(i, j int) bool {
+		if p[i].AccessCount != p[j].AccessCount {
+			return p[i].AccessCount < p[j].AccessCount
+		}
+		return p[i].AccessedOn.Before(p[j].AccessedOn)
+	})
 
 	var r []*CacheItem
-	c := int64(0)
 	for _, v := range p {
-		if c >= count {
+		if int64(len(r)) >= count {
 			break
 		}
-		item, ok := table.items[v.Key]
-		if ok {
-			r = append(r, item)
-		}
-		c++
+		r = append(r, table.items[v.Key])
 	}
 
 	return r
@@ -317,8 +2547,27 @@ func (table *CacheTable) MostAccessed(count int64) []*CacheItem {
 
 // Internal logging method for convenience
 func (table *CacheTable) log(v ...interface{}) {
+	if table.slogger != nil {
+		msg := fmt.Sprintln(v...)
+		table.slogger.Info(msg[:len(msg)-1], "table", table.name)
+		return
+	}
 	if table.logger == nil {
 		return
 	}
 	table.logger.Println(v...)
 }
+
+// Internal logging method for item events, logs msg with the item's
+// attributes to the structured logger or v to the plain logger
+func (table *CacheTable) logItem(msg string, item *CacheItem, v ...interface{}) {
+	if table.slogger == nil {
+		table.log(v...)
+		return
+	}
+	table.slogger.Info(msg,
+		"table", table.name,
+		"key", item.key,
+		"lifespan", item.LifeSpan(),
+		"access_count", item.AccessCount())
+}
@@ -0,0 +1,1788 @@
+package cpcache2go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeysSnapshot(t *testing.T) {
+	table := newCacheTable("keys")
+	for i := 0; i < 3; i++ {
+		table.Add(i, 0, i)
+	}
+
+	keys := table.Keys()
+	table.Add(3, 0, 3)
+	table.Delete(0)
+
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 keys, got %d", len(keys))
+	}
+	seen := make(map[interface{}]bool)
+	for _, k := range keys {
+		seen[k] = true
+	}
+	for i := 0; i < 3; i++ {
+		if !seen[i] {
+			t.Errorf("key %d missing from snapshot", i)
+		}
+	}
+	if seen[3] {
+		t.Error("snapshot picked up a key added later")
+	}
+}
+
+func TestIncrementConcurrent(t *testing.T) {
+	table := newCacheTable("increment")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := table.Increment("counter", 1, 0); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	r, err := table.Value("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := r.Data().(int64); v != 100*100 {
+		t.Errorf("expected %d, got %d", 100*100, v)
+	}
+	if v, err := table.Decrement("counter", 10000, 0); err != nil || v != 0 {
+		t.Errorf("expected 0, got %d, %v", v, err)
+	}
+}
+
+func TestIncrementNotInt64(t *testing.T) {
+	table := newCacheTable("increment")
+	table.Add("key", 0, "data")
+
+	if _, err := table.Increment("key", 1, 0); err != ErrNotInt64 {
+		t.Errorf("expected ErrNotInt64, got %v", err)
+	}
+}
+
+func TestAboutToExpireCallback(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("expire")
+	table.SetClock(clock)
+
+	var deleted, expired atomic.Int32
+	r := table.Add("deleted", time.Second, "data")
+	r.SetAboutToExpireCallback(func(key interface{}) { deleted.Add(1) })
+	r = table.Add("expired", time.Second, "data")
+	r.SetAboutToExpireCallback(func(key interface{}) {
+		if key != "expired" {
+			t.Errorf("callback called with key %v", key)
+		}
+		expired.Add(1)
+	})
+
+	// a manual delete is no expiration
+	if _, err := table.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Load() != 0 {
+		t.Error("about-to-expire callback triggered by Delete")
+	}
+
+	clock.Advance(time.Second)
+	waitFor(t, func() bool { return expired.Load() > 0 })
+	if table.Exists("expired") || expired.Load() != 1 {
+		t.Errorf("expected one about-to-expire call, got %d", expired.Load())
+	}
+}
+
+func TestUpdateLifeSpan(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("lifespan")
+	table.SetClock(clock)
+
+	table.Add("session", time.Second, "data")
+	r := table.Add("short", time.Minute, "data")
+	clock.Advance(900 * time.Millisecond)
+	if err := table.UpdateLifeSpan("session", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Second)
+	table.DeleteExpired()
+	if !table.Exists("session") {
+		t.Error("extended item expired")
+	}
+
+	// shorter than the time it's already idle
+	if err := table.UpdateLifeSpan("short", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if r.LifeSpan() != time.Second {
+		t.Errorf("expected lifespan of 1s, got %v", r.LifeSpan())
+	}
+	waitFor(t, func() bool { return !table.Exists("short") })
+
+	if err := table.UpdateLifeSpan("missing", time.Second); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := table.UpdateLifeSpan("session", -time.Second); err != ErrInvalidLifeSpan {
+		t.Errorf("expected ErrInvalidLifeSpan, got %v", err)
+	}
+}
+
+func TestForeachUntil(t *testing.T) {
+	table := newCacheTable("foreach")
+	for i := 0; i < 100; i++ {
+		table.Add(i, 0, i%10)
+	}
+
+	visited, matches := 0, 0
+	table.ForeachUntil(func(k interface{}, item *CacheItem) bool {
+		visited++
+		if item.Data() == 0 {
+			matches++
+			return false
+		}
+		return true
+	})
+	if matches != 1 {
+		t.Errorf("expected iteration to stop at the first match, got %d matches", matches)
+	}
+	// one in ten items matches, so on average about ten items are visited
+	if visited > 91 {
+		t.Errorf("visited %d items before a match", visited)
+	}
+
+	visited = 0
+	table.ForeachUntil(func(k interface{}, item *CacheItem) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("expected 1 visited item, got %d", visited)
+	}
+
+	if n := len(table.Filter(func(item *CacheItem) bool { return item.Data() == 0 })); n != 10 {
+		t.Errorf("expected 10 filtered items, got %d", n)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	table := newCacheTable("prefix")
+	table.Add("user:1:profile", 0, 1)
+	table.Add("user:2:profile", 0, 2)
+	table.Add("users", 0, 3)
+	table.Add("group:1", 0, 4)
+	table.Add(1, 0, 5)
+	table.Add([2]string{"user:", "x"}, 0, 6)
+
+	var deleted atomic.Int32
+	table.SetAboutToDeleteItemCallback(func(item *CacheItem) { deleted.Add(1) })
+
+	if n := table.DeletePrefix("user:"); n != 2 {
+		t.Errorf("expected 2 deleted items, got %d", n)
+	}
+	if deleted.Load() != 2 {
+		t.Errorf("expected 2 delete callbacks, got %d", deleted.Load())
+	}
+	if table.Count() != 4 || table.Exists("user:1:profile") || !table.Exists("users") || !table.Exists(1) {
+		t.Error("wrong items deleted")
+	}
+
+	n := table.DeleteMatch(func(key interface{}) bool {
+		_, ok := key.(int)
+		return ok
+	})
+	if n != 1 || table.Exists(1) {
+		t.Errorf("expected the int key to be deleted, deleted %d", n)
+	}
+}
+
+func TestGetDoesNotLoad(t *testing.T) {
+	table := newCacheTable("get")
+	var calls atomic.Int32
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		calls.Add(1)
+		return NewCacheItem(key, 0, "loaded")
+	})
+
+	if r, ok := table.Get("missing"); ok || r != nil {
+		t.Errorf("expected a miss, got %v", r)
+	}
+	if calls.Load() != 0 {
+		t.Error("Get invoked the data-loader")
+	}
+
+	table.Add("key", 0, "data")
+	r, ok := table.Get("key")
+	if !ok || r.Data() != "data" {
+		t.Fatalf("expected the item, got %v", r)
+	}
+	if r.AccessCount() != 1 {
+		t.Errorf("expected access count 1, got %d", r.AccessCount())
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	table := newCacheTable("cas")
+	table.Add("config", 0, map[string]int{"version": 1})
+
+	swapped, err := table.CompareAndSwap("config", map[string]int{"version": 1}, map[string]int{"version": 2}, 0)
+	if err != nil || !swapped {
+		t.Fatalf("expected a swap, got %v, %v", swapped, err)
+	}
+	r, _ := table.Peek("config")
+	if r.Data().(map[string]int)["version"] != 2 {
+		t.Errorf("data not swapped: %v", r.Data())
+	}
+
+	// stale read
+	swapped, err = table.CompareAndSwap("config", map[string]int{"version": 1}, map[string]int{"version": 3}, 0)
+	if err != nil || swapped {
+		t.Errorf("expected no swap on mismatch, got %v, %v", swapped, err)
+	}
+	if r.Data().(map[string]int)["version"] != 2 {
+		t.Errorf("data changed on mismatch: %v", r.Data())
+	}
+
+	swapped, err = table.CompareAndSwap("missing", nil, 1, 0)
+	if !errors.Is(err, ErrKeyNotFound) || swapped {
+		t.Errorf("expected ErrKeyNotFound, got %v, %v", swapped, err)
+	}
+}
+
+// fakeStore is an in-memory backing store for the write-through tests
+type fakeStore struct {
+	mu   sync.Mutex
+	data map[interface{}]interface{}
+	log  []string
+	fail error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{data: make(map[interface{}]interface{})}
+}
+
+func (s *fakeStore) write(key, data interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	s.data[key] = data
+	s.log = append(s.log, "write "+key.(string))
+	return nil
+}
+
+func (s *fakeStore) delete(key interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	delete(s.data, key)
+	s.log = append(s.log, "delete "+key.(string))
+	return nil
+}
+
+func TestWriteThrough(t *testing.T) {
+	table := newCacheTable("store")
+	store := newFakeStore()
+	table.SetWriteThrough(func(key, data interface{}) error {
+		// the callbacks run with the table locked, so peeking is safe
+		if _, ok := table.items[key]; ok && key == "new" {
+			t.Error("item visible before it was written to the store")
+		}
+		return store.write(key, data)
+	}, func(key interface{}) error {
+		if _, ok := table.items[key]; !ok {
+			t.Error("item gone before it was deleted from the store")
+		}
+		return store.delete(key)
+	})
+	table.SetAddedItemCallback(func(item *CacheItem) {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		store.log = append(store.log, "added "+item.Key().(string))
+	})
+
+	if _, err := table.AddChecked("new", 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Delete("new"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"write new", "added new", "delete new"}
+	if !reflect.DeepEqual(store.log, expected) {
+		t.Errorf("expected %v, got %v", expected, store.log)
+	}
+
+	table.Add("kept", 0, 1)
+	store.fail = errors.New("store down")
+	if _, err := table.AddChecked("rejected", 0, 1); err != store.fail {
+		t.Errorf("expected the store's error, got %v", err)
+	}
+	if table.Exists("rejected") {
+		t.Error("item added although the store failed")
+	}
+	if _, err := table.Delete("kept"); err != store.fail {
+		t.Errorf("expected the store's error, got %v", err)
+	}
+	if !table.Exists("kept") {
+		t.Error("item deleted although the store failed")
+	}
+}
+
+func TestLeastAccessed(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("accessed")
+	table.SetClock(clock)
+	for _, key := range []string{"hot", "warm", "cold", "colder", "coldest"} {
+		table.Add(key, 0, key)
+	}
+	accesses := map[string]int{"hot": 5, "warm": 2, "colder": 1, "cold": 1}
+	// colder is accessed before cold, so it's idle for longer
+	for _, key := range []string{"hot", "warm", "colder", "cold"} {
+		clock.Advance(time.Second)
+		for i := 0; i < accesses[key]; i++ {
+			table.Value(key)
+		}
+	}
+
+	var keys []interface{}
+	for _, item := range table.LeastAccessed(4) {
+		keys = append(keys, item.Key())
+	}
+	expected := []interface{}{"coldest", "colder", "cold", "warm"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+	if n := len(table.LeastAccessed(10)); n != 5 {
+		t.Errorf("expected all 5 items, got %d", n)
+	}
+}
+
+// newAccessedTable return a table with n items with scattered access counts
+func newAccessedTable(n int) *CacheTable {
+	table := newCacheTable("accessed")
+	items := make([]*CacheItem, n)
+	for i := range items {
+		items[i] = NewCacheItem(i, 0, i)
+		items[i].accessCount = int64((i * 7919) % n)
+	}
+	table.AddBatch(items)
+
+	return table
+}
+
+// mostAccessedSorted is MostAccessed as it was before the bounded heap,
+// sorting all items
+func mostAccessedSorted(table *CacheTable, count int64) []*CacheItem {
+	table.RLock()
+	defer table.RUnlock()
+
+	p := make(CacheItemPairList, 0, len(table.items))
+	for k, v := range table.items {
+		v.RLock()
+		p = append(p, CacheItemPair{Key: k, AccessCount: v.accessCount})
+		v.RUnlock()
+	}
+	sort.Sort(p)
+
+	var r []*CacheItem
+	for i := 0; i < len(p) && int64(i) < count; i++ {
+		r = append(r, table.items[p[i].Key])
+	}
+
+	return r
+}
+
+func TestMostAccessed(t *testing.T) {
+	table := newAccessedTable(1000)
+	top := table.MostAccessed(5)
+	expected := mostAccessedSorted(table, 5)
+	if len(top) != 5 {
+		t.Fatalf("expected 5 items, got %d", len(top))
+	}
+	for i := range top {
+		if top[i].AccessCount() != expected[i].AccessCount() {
+			t.Errorf("item %d: expected access count %d, got %d", i, expected[i].AccessCount(), top[i].AccessCount())
+		}
+	}
+	if n := len(table.MostAccessed(2000)); n != 1000 {
+		t.Errorf("expected all 1000 items, got %d", n)
+	}
+}
+
+var (
+	millionOnce  sync.Once
+	millionTable *CacheTable
+)
+
+// Results for the top 5 of a 1M item table:
+//
+//	BenchmarkMostAccessedHeap     12     93203083 ns/op       792 B/op    13 allocs/op
+//	BenchmarkMostAccessedSorted    4    303179569 ns/op  48005208 B/op     3 allocs/op
+func benchmarkMostAccessed(b *testing.B, f func(table *CacheTable, count int64) []*CacheItem) {
+	millionOnce.Do(func() { millionTable = newAccessedTable(1000000) })
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f(millionTable, 5)
+	}
+}
+
+func BenchmarkMostAccessedHeap(b *testing.B) {
+	benchmarkMostAccessed(b, (*CacheTable).MostAccessed)
+}
+
+func BenchmarkMostAccessedSorted(b *testing.B) {
+	benchmarkMostAccessed(b, mostAccessedSorted)
+}
+
+func TestAddIfAbsent(t *testing.T) {
+	table := newCacheTable("absent")
+	var loads atomic.Int32
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		loads.Add(1)
+		return nil
+	})
+
+	r, added := table.AddIfAbsent("key", 0, "first")
+	if !added || r.Data() != "first" {
+		t.Fatalf("expected the new item, got %v, %v", r, added)
+	}
+	existing, added := table.AddIfAbsent("key", 0, "second")
+	if added || existing != r {
+		t.Errorf("expected the existing item, got %v, %v", existing, added)
+	}
+	if r.Data() != "first" {
+		t.Error("existing item was overwritten")
+	}
+	if loads.Load() != 0 {
+		t.Error("AddIfAbsent consulted the data-loader")
+	}
+
+	if _, added := table.AddIfAbsent(nil, 0, "data"); added || table.Count() != 1 {
+		t.Error("expected an item with a nil key not to be added")
+	}
+}
+
+func TestAddIfAbsentConcurrent(t *testing.T) {
+	table := newCacheTable("absent")
+	var wg sync.WaitGroup
+	var winners atomic.Int32
+	items := make([]*CacheItem, 50)
+	for i := range items {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, added := table.AddIfAbsent("key", 0, i)
+			if added {
+				winners.Add(1)
+			}
+			items[i] = r
+		}(i)
+	}
+	wg.Wait()
+
+	if winners.Load() != 1 {
+		t.Errorf("expected a single insertion, got %d", winners.Load())
+	}
+	for _, r := range items {
+		if r != items[0] {
+			t.Fatal("callers got different items")
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("replace")
+	table.SetClock(clock)
+
+	if _, err := table.Replace("missing", 0, "data"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	if table.Exists("missing") {
+		t.Error("Replace created a new item")
+	}
+
+	r := table.Add("key", 0, "old")
+	created := r.CreatedOn()
+	table.Value("key")
+	clock.Advance(time.Minute)
+
+	replaced, err := table.Replace("key", time.Hour, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced != r || r.Data() != "new" || r.LifeSpan() != time.Hour {
+		t.Errorf("item not updated in place: %v", replaced)
+	}
+	if !r.CreatedOn().Equal(created) || r.AccessCount() != 1 {
+		t.Error("creation time or access count not preserved")
+	}
+	if !r.AccessedOn().Equal(clock.Now()) {
+		t.Error("access time not reset")
+	}
+	if next, ok := table.NextCleanup(); !ok || !next.Equal(clock.Now().Add(time.Hour)) {
+		t.Errorf("expiration check not armed for the new lifespan: %v", next)
+	}
+}
+
+func TestSlogger(t *testing.T) {
+	table := newCacheTable("slog")
+	var buf bytes.Buffer
+	table.SetSlogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	table.Add("key", time.Minute, "data")
+	table.Value("key")
+	buf.Reset()
+	table.Delete("key")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"table":        "slog",
+		"key":          "key",
+		"lifespan":     float64(time.Minute),
+		"access_count": float64(1),
+	}
+	for attr, v := range expected {
+		if record[attr] != v {
+			t.Errorf("expected %s=%v, got %v", attr, v, record[attr])
+		}
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	table := newCacheTable("many")
+	var loads atomic.Int32
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		loads.Add(1)
+		return NewCacheItem(key, 0, "loaded")
+	})
+	a := table.Add("a", 0, 1)
+	b := table.Add("b", 0, 2)
+	c := table.Add("c", 0, 3)
+
+	found, missing := table.GetMany([]interface{}{"a", "x", "b", "y"})
+	if len(found) != 2 || found["a"] != a || found["b"] != b {
+		t.Errorf("expected a and b to be found, got %v", found)
+	}
+	if !reflect.DeepEqual(missing, []interface{}{"x", "y"}) {
+		t.Errorf("expected x and y to be missing, got %v", missing)
+	}
+	if a.AccessCount() != 1 || b.AccessCount() != 1 || c.AccessCount() != 0 {
+		t.Error("expected access counts to increase only for hits")
+	}
+	if loads.Load() != 0 {
+		t.Error("GetMany invoked the data-loader")
+	}
+}
+
+// expireSteps adds 1000 items expiring within 10ms and advances the clock in
+// 10µs steps until all of them expired. It returns how many timers were armed
+func expireSteps(t *testing.T, minInterval time.Duration) int {
+	clock := newFakeClock()
+	table := newCacheTable("thrash")
+	table.SetClock(clock)
+	table.SetMinCleanupInterval(minInterval)
+	for i := 0; i < 1000; i++ {
+		table.Add(i, time.Second+time.Duration(i)*10*time.Microsecond, i)
+	}
+
+	clock.Advance(time.Second - 10*time.Microsecond)
+	for i := 0; table.Count() > 0; i++ {
+		if i > 100000 {
+			t.Fatal("items didn't expire")
+		}
+		clock.Advance(10 * time.Microsecond)
+		// wait for the check triggered by a firing timer to re-arm
+		waitFor(t, func() bool {
+			next, ok := table.NextCleanup()
+			return !ok || next.After(clock.Now())
+		})
+	}
+
+	return clock.Armed()
+}
+
+func TestMinCleanupInterval(t *testing.T) {
+	if n := expireSteps(t, 0); n < 900 {
+		t.Fatalf("expected the timer to fire for about every item without a minimum interval, fired %d times", n)
+	}
+	if n := expireSteps(t, 100*time.Millisecond); n > 5 {
+		t.Errorf("expected the timer to fire a few times, fired %d times", n)
+	}
+}
+
+func TestExpireCallback(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("expire")
+	table.SetClock(clock)
+
+	var mu sync.Mutex
+	expired := make(map[interface{}]int)
+	table.SetExpireCallback(func(item *CacheItem) {
+		mu.Lock()
+		defer mu.Unlock()
+		expired[item.Key()]++
+	})
+	for i := 0; i < 5; i++ {
+		table.Add(i, time.Duration(i+1)*time.Second, i)
+	}
+	table.Add("deleted", time.Second, "data")
+	table.Delete("deleted")
+
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		waitFor(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(expired) == i+1
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 5 {
+		t.Errorf("expected 5 expired items, got %v", expired)
+	}
+	for key, n := range expired {
+		if n != 1 {
+			t.Errorf("callback fired %d times for %v", n, key)
+		}
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	table := newCacheTable("jitter")
+	table.SetTTLJitter(0.1)
+
+	const ttl = time.Hour
+	lo, hi := time.Duration(math.MaxInt64), time.Duration(0)
+	distinct := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		r := table.Add(i, ttl, i)
+		d := r.LifeSpan()
+		if d < ttl-ttl/10 || d > ttl+ttl/10 {
+			t.Fatalf("lifespan %v outside of +/-10%%", d)
+		}
+		lo, hi = min(lo, d), max(hi, d)
+		distinct[d] = true
+	}
+	if hi-lo < ttl/10 || len(distinct) < 900 {
+		t.Errorf("expected lifespans spread over the jitter range, got %v to %v with %d distinct values", lo, hi, len(distinct))
+	}
+
+	// the fraction is clamped so lifespans stay positive
+	table.SetTTLJitter(5)
+	for i := 0; i < 1000; i++ {
+		if d := table.Add(i, ttl, i).LifeSpan(); d <= 0 || d >= 2*ttl {
+			t.Fatalf("lifespan %v outside of (0, 2h)", d)
+		}
+	}
+
+	table.SetTTLJitter(0)
+	if d := table.Add("exact", ttl, 0).LifeSpan(); d != ttl {
+		t.Errorf("expected lifespan %v without jitter, got %v", ttl, d)
+	}
+}
+
+func TestFlushWithCallbacks(t *testing.T) {
+	table := newCacheTable("flush")
+	for i := 0; i < 10; i++ {
+		table.Add(i, time.Minute, i)
+	}
+	deleted := make(map[interface{}]int)
+	table.SetAboutToDeleteItemCallback(func(item *CacheItem) {
+		deleted[item.Key()]++
+	})
+
+	table.FlushWithCallbacks()
+	if table.Count() != 0 {
+		t.Errorf("expected an empty table, got %d items", table.Count())
+	}
+	if len(deleted) != 10 {
+		t.Errorf("expected callbacks for 10 items, got %d", len(deleted))
+	}
+	for key, n := range deleted {
+		if n != 1 {
+			t.Errorf("callback fired %d times for %v", n, key)
+		}
+	}
+
+	// the plain Flush keeps skipping the callbacks
+	table.Add("key", 0, "data")
+	table.Flush()
+	if _, ok := deleted["key"]; ok {
+		t.Error("Flush fired the callbacks")
+	}
+}
+
+func TestPeek(t *testing.T) {
+	table := newCacheTable("peek")
+	var loads atomic.Int32
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		loads.Add(1)
+		return NewCacheItem(key, 0, "loaded")
+	})
+	r := table.Add("key", time.Minute, "data")
+	accessed := r.AccessedOn()
+
+	for i := 0; i < 5; i++ {
+		if got, ok := table.Peek("key"); !ok || got != r {
+			t.Fatalf("expected the item, got %v", got)
+		}
+	}
+	if r.AccessCount() != 0 || !r.AccessedOn().Equal(accessed) {
+		t.Errorf("Peek touched the item: access count %d", r.AccessCount())
+	}
+
+	table.Value("key")
+	if r.AccessCount() != 1 {
+		t.Errorf("expected Value to bump the access count to 1, got %d", r.AccessCount())
+	}
+
+	if _, ok := table.Peek("missing"); ok || loads.Load() != 0 {
+		t.Error("Peek invoked the data-loader")
+	}
+}
+
+func TestValueWithTTL(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("ttl")
+	table.SetClock(clock)
+	sliding := table.Add("sliding", time.Minute, "data")
+	table.Add("forever", 0, "data")
+
+	clock.Skip(40 * time.Second)
+	if d := sliding.RemainingLife(); d != 20*time.Second {
+		t.Errorf("expected 20s left, got %v", d)
+	}
+	// the access keeps the item alive for another lifespan
+	if _, d, err := table.ValueWithTTL("sliding"); err != nil || d != time.Minute {
+		t.Errorf("expected 1m left after the access, got %v, %v", d, err)
+	}
+	if _, d, err := table.ValueWithTTL("forever"); err != nil || d != -1 {
+		t.Errorf("expected -1 for an item which never expires, got %v, %v", d, err)
+	}
+
+	clock.Skip(59*time.Second + 900*time.Millisecond)
+	if d := sliding.RemainingLife(); d != 100*time.Millisecond {
+		t.Errorf("expected 100ms left, got %v", d)
+	}
+	clock.Skip(time.Second)
+	if d := sliding.RemainingLife(); d != 0 {
+		t.Errorf("expected 0 for an item past its lifespan, got %v", d)
+	}
+
+	if _, _, err := table.ValueWithTTL("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestValueWithTTLDeadline(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("ttl")
+	table.SetClock(clock)
+	table.Add("key", time.Minute, "data")
+	table.ExpireAt("key", clock.Now().Add(30*time.Second))
+
+	clock.Skip(10 * time.Second)
+	// keeping it alive can't extend it past the deadline
+	if _, d, err := table.ValueWithTTL("key"); err != nil || d != 20*time.Second {
+		t.Errorf("expected 20s left until the deadline, got %v, %v", d, err)
+	}
+}
+
+func TestCountWhereSumInt64(t *testing.T) {
+	table := newCacheTable("aggregate")
+	for i := 0; i < 10; i++ {
+		table.Add(i, 0, int64(i))
+		for j := 0; j < i; j++ {
+			table.Value(i)
+		}
+	}
+
+	hot := table.CountWhere(func(item *CacheItem) bool { return item.AccessCount() > 5 })
+	if hot != 4 {
+		t.Errorf("expected 4 items accessed more than 5 times, got %d", hot)
+	}
+	sum := table.SumInt64(func(item *CacheItem) int64 { return item.Data().(int64) })
+	if sum != 45 {
+		t.Errorf("expected a sum of 45, got %d", sum)
+	}
+
+	empty := newCacheTable("empty")
+	if empty.CountWhere(func(*CacheItem) bool { return true }) != 0 || empty.SumInt64(func(*CacheItem) int64 { return 1 }) != 0 {
+		t.Error("expected zero aggregates for an empty table")
+	}
+}
+
+func TestUpdateEach(t *testing.T) {
+	table := newCacheTable("update")
+	for i := 0; i < 10; i++ {
+		table.Add(i, 0, i)
+	}
+	var deleted []interface{}
+	table.SetAboutToDeleteItemCallback(func(item *CacheItem) {
+		// called after the lock was released
+		table.Exists(item.Key())
+		deleted = append(deleted, item.Key())
+	})
+
+	table.UpdateEach(func(key interface{}, item *CacheItem) (interface{}, bool) {
+		v := item.Data().(int)
+		return v * 2, v%2 == 0
+	})
+
+	if table.Count() != 5 || len(deleted) != 5 {
+		t.Fatalf("expected 5 items kept and 5 deleted, got %d and %d", table.Count(), len(deleted))
+	}
+	for i := 0; i < 10; i += 2 {
+		r, ok := table.Peek(i)
+		if !ok || r.Data() != i*2 {
+			t.Errorf("expected %d for key %d, got %v", i*2, i, r)
+		}
+	}
+	for _, key := range deleted {
+		if key.(int)%2 == 0 {
+			t.Errorf("even key %v deleted", key)
+		}
+	}
+}
+
+func TestUpdateEachStoreFailure(t *testing.T) {
+	table := newCacheTable("update")
+	table.Add("a", 0, 1)
+	table.Add("b", 0, 2)
+	failed := errors.New("store down")
+	table.SetWriteThrough(func(key, data interface{}) error {
+		if key == "a" {
+			return failed
+		}
+		return nil
+	}, func(key interface{}) error { return failed })
+
+	table.UpdateEach(func(key interface{}, item *CacheItem) (interface{}, bool) {
+		return item.Data().(int) * 10, key == "a"
+	})
+
+	// neither change reached the store, so both items are untouched
+	if r, _ := table.Peek("a"); r.Data() != 1 {
+		t.Errorf("expected a to keep its data, got %v", r.Data())
+	}
+	if !table.Exists("b") {
+		t.Error("expected b to be kept")
+	}
+}
+
+func TestAddOrRefresh(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("refresh")
+	table.SetClock(clock)
+
+	r := table.AddOrRefresh("key", time.Hour, "old")
+	if r == nil || r.Data() != "old" {
+		t.Fatalf("expected a new item, got %v", r)
+	}
+	created := r.CreatedOn()
+	table.Value("key")
+	clock.Advance(time.Minute)
+
+	refreshed := table.AddOrRefresh("key", time.Minute, "new")
+	if refreshed != r || r.Data() != "new" || r.LifeSpan() != time.Minute {
+		t.Fatalf("item not refreshed in place: %v", refreshed)
+	}
+	if !r.CreatedOn().Equal(created) || r.AccessCount() != 1 {
+		t.Error("creation time or access count not preserved")
+	}
+	if !r.AccessedOn().Equal(clock.Now()) {
+		t.Errorf("expected access time %v, got %v", clock.Now(), r.AccessedOn())
+	}
+	// the shorter lifespan is more imminent than the armed timer
+	if next, ok := table.NextCleanup(); !ok || !next.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("expiration check not re-armed for the new lifespan: %v", next)
+	}
+}
+
+func TestReentrantDeleteCallback(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("reentrant")
+	table.SetClock(clock)
+	table.SetAboutToDeleteItemCallback(func(item *CacheItem) {
+		// moves the item to a tombstone key
+		if key, ok := item.Key().(string); ok {
+			table.Add(key+"-deleted", 0, item.Data())
+			table.Value(key + "-deleted")
+		}
+	})
+	table.Add("manual", 0, "a")
+	table.Add("expired", time.Second, "b")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		table.Delete("manual")
+		clock.Advance(time.Second)
+		waitFor(t, func() bool { return table.Exists("expired-deleted") })
+		table.DeleteBatch([]interface{}{"manual-deleted"})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock in a re-entrant delete callback")
+	}
+
+	for _, key := range []string{"manual-deleted-deleted", "expired-deleted"} {
+		if !table.Exists(key) {
+			t.Errorf("expected %s to be added by the callback", key)
+		}
+	}
+	if table.Count() != 2 {
+		t.Errorf("expected 2 items, got %d", table.Count())
+	}
+}
+
+func TestStopLeavesNoTimers(t *testing.T) {
+	before := runtime.NumGoroutine()
+	m := NewCacheManager()
+	var expired atomic.Int32
+	for i := 0; i < 50; i++ {
+		table := m.Cache(strconv.Itoa(i))
+		table.SetExpireCallback(func(item *CacheItem) { expired.Add(1) })
+		table.Add("key", 20*time.Millisecond, "data")
+	}
+	extra := newCacheTable("extra")
+	extra.Add("key", 20*time.Millisecond, "data")
+
+	m.StopAll()
+	extra.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	if n := expired.Load(); n != 0 {
+		t.Errorf("%d items expired after the tables were stopped", n)
+	}
+	if !extra.Exists("key") {
+		t.Error("item expired after the table was stopped")
+	}
+	// further adds don't arm the timer again
+	extra.Add("other", time.Millisecond, "data")
+	if _, ok := extra.NextCleanup(); ok {
+		t.Error("expiration timer re-armed after Stop")
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected at most %d goroutines, got %d", before, after)
+	}
+}
+
+func TestAddChecked(t *testing.T) {
+	table := newCacheTable("checked")
+	table.SetCapacity(1)
+	table.SetOverflowPolicy(Reject)
+
+	if r, err := table.AddChecked(nil, 0, "data"); err != ErrNilKey || r != nil {
+		t.Errorf("expected ErrNilKey, got %v, %v", r, err)
+	}
+	if r, err := table.AddChecked([]int{1}, 0, "data"); err != ErrInvalidKey || r != nil {
+		t.Errorf("expected ErrInvalidKey, got %v, %v", r, err)
+	}
+	if r, err := table.AddChecked("a", 0, "data"); err != nil || r == nil {
+		t.Fatalf("expected the item, got %v, %v", r, err)
+	}
+	if r, err := table.AddChecked("b", 0, "data"); err != ErrCacheFull || r != nil {
+		t.Errorf("expected ErrCacheFull, got %v, %v", r, err)
+	}
+	// replacing an existing key needs no room
+	if _, err := table.AddChecked("a", 0, "new"); err != nil {
+		t.Errorf("expected the item to be replaced, got %v", err)
+	}
+
+	// the unchecked variant returns the item anyway
+	if r := table.Add("b", 0, "data"); r == nil || r.Data() != "data" {
+		t.Errorf("expected Add to return the rejected item, got %v", r)
+	}
+	if table.Count() != 1 || table.Exists("b") {
+		t.Error("rejected item was added")
+	}
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	table := newCacheTable("normalized")
+	table.SetKeyNormalizer(func(key interface{}) interface{} {
+		if s, ok := key.(string); ok {
+			return strings.ToLower(s)
+		}
+		return key
+	})
+
+	table.Add("Foo", 0, 1)
+	table.Add("FOO", 0, 2)
+	table.Add("foo", 0, 3)
+	if table.Count() != 1 {
+		t.Fatalf("expected mixed-case keys to collapse into one item, got %d", table.Count())
+	}
+	r, err := table.Value("fOo")
+	if err != nil || r.Data() != 3 || r.Key() != "foo" {
+		t.Errorf("expected the normalized item, got %v, %v", r, err)
+	}
+	if !table.Exists("FoO") {
+		t.Error("Exists doesn't normalize the key")
+	}
+	if _, err := table.Delete("FOO"); err != nil || table.Count() != 0 {
+		t.Errorf("Delete doesn't normalize the key: %v", err)
+	}
+}
+
+func TestExpirationModes(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("modes")
+	table.SetClock(clock)
+	table.AddWithExpirationMode("idle", time.Minute, "data", IdleTimeout)
+	table.AddWithExpirationMode("created", time.Minute, "data", CreationTTL)
+
+	for i := 0; i < 5; i++ {
+		clock.Advance(20 * time.Second)
+		table.Value("idle")
+		table.Value("created")
+	}
+	waitFor(t, func() bool { return !table.Exists("created") })
+	if !table.Exists("idle") {
+		t.Error("idle item expired although it was kept alive")
+	}
+}
+
+func TestLoadOrStore(t *testing.T) {
+	table := newCacheTable("loadorstore")
+	var loads atomic.Int32
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		loads.Add(1)
+		return nil
+	})
+
+	stored, loaded := table.LoadOrStore("key", 0, "first")
+	if loaded || stored == nil || stored.Data() != "first" {
+		t.Fatalf("expected the new item to be stored, got %v, %v", stored, loaded)
+	}
+	actual, loaded := table.LoadOrStore("key", 0, "second")
+	if !loaded || actual != stored || actual.Data() != "first" {
+		t.Errorf("expected the existing item, got %v, %v", actual, loaded)
+	}
+	if actual.AccessCount() != 1 {
+		t.Errorf("expected the existing item to be kept alive, got access count %d", actual.AccessCount())
+	}
+	if loads.Load() != 0 {
+		t.Error("LoadOrStore consulted the data-loader")
+	}
+	if r, loaded := table.LoadOrStore(nil, 0, "data"); r != nil || loaded {
+		t.Errorf("expected nil for a nil key, got %v, %v", r, loaded)
+	}
+}
+
+func TestLoadOrStoreConcurrent(t *testing.T) {
+	table := newCacheTable("loadorstore")
+	var wg sync.WaitGroup
+	var stores atomic.Int32
+	items := make([]*CacheItem, 50)
+	for i := range items {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, loaded := table.LoadOrStore("key", 0, i)
+			if !loaded {
+				stores.Add(1)
+			}
+			items[i] = r
+		}(i)
+	}
+	wg.Wait()
+
+	if stores.Load() != 1 {
+		t.Errorf("expected a single store, got %d", stores.Load())
+	}
+	for _, r := range items {
+		if r != items[0] {
+			t.Fatal("callers got different items")
+		}
+	}
+	if n := items[0].AccessCount(); n != 49 {
+		t.Errorf("expected 49 loads to keep the item alive, got %d", n)
+	}
+}
+
+func TestCallbackPanicHandler(t *testing.T) {
+	table := newCacheTable("panic")
+	var recovered []interface{}
+	table.SetCallbackPanicHandler(func(r interface{}) {
+		recovered = append(recovered, r)
+	})
+	table.SetAddedItemCallback(func(item *CacheItem) {
+		panic("broken callback")
+	})
+
+	table.Add("a", 0, "data")
+	if len(recovered) != 1 || recovered[0] != "broken callback" {
+		t.Fatalf("expected the handler to get the panic, got %v", recovered)
+	}
+
+	// the table is still usable, so the lock was released
+	table.RemoveAddedItemCallbacks()
+	table.Add("b", 0, "data")
+	if _, err := table.Delete("a"); err != nil || table.Count() != 1 {
+		t.Errorf("table unusable after a panicking callback: %v", err)
+	}
+}
+
+func TestRenewThreshold(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("renew")
+	table.SetClock(clock)
+	table.SetRenewThreshold(0.2)
+	r := table.Add("key", 10*time.Second, "data")
+	added := r.AccessedOn()
+
+	clock.Skip(5 * time.Second)
+	table.Value("key")
+	if !r.AccessedOn().Equal(added) || r.AccessCount() != 0 {
+		t.Error("item renewed by a read early in its life")
+	}
+
+	clock.Skip(4 * time.Second)
+	table.Value("key")
+	if !r.AccessedOn().Equal(clock.Now()) || r.AccessCount() != 1 {
+		t.Error("item not renewed by a read near its expiry")
+	}
+}
+
+func TestLazyExpiration(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("lazy")
+	table.SetClock(clock)
+	table.SetLazyExpiration(true)
+
+	table.Add("key", time.Second, "data")
+	table.Add("other", time.Second, "data")
+	table.Add("swept", time.Second, "data")
+	if n := clock.Armed(); n != 0 {
+		t.Fatalf("expected no timer in lazy mode, %d were armed", n)
+	}
+
+	clock.Advance(time.Second)
+	if table.Count() != 3 {
+		t.Fatal("items expired without being accessed")
+	}
+	if _, err := table.Value("key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected the expired item to be gone, got %v", err)
+	}
+	if table.Count() != 2 {
+		t.Error("expired item not removed on access")
+	}
+	if table.Exists("other") {
+		t.Error("Exists reported an expired item")
+	}
+	if n := table.DeleteExpired(); n != 1 || table.Count() != 0 {
+		t.Errorf("expected the sweep to remove the last item, removed %d", n)
+	}
+}
+
+func TestValuesItems(t *testing.T) {
+	table := newCacheTable("values")
+	for i := 0; i < 25; i++ {
+		table.Add(i, 0, i)
+	}
+
+	values, items := table.Values(), table.Items()
+	if len(values) != table.Count() || len(items) != 25 {
+		t.Fatalf("expected 25 values and items, got %d and %d", len(values), len(items))
+	}
+	sum := 0
+	for _, v := range values {
+		sum += v.(int)
+	}
+	if sum != 300 {
+		t.Errorf("expected the values to sum to 300, got %d", sum)
+	}
+
+	// point-in-time copies
+	table.Add("late", 0, 0)
+	if len(values) != 25 || len(items) != 25 {
+		t.Error("snapshots changed with the table")
+	}
+}
+
+func TestExpiringWithin(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("expiring")
+	table.SetClock(clock)
+	for key, d := range map[string]time.Duration{
+		"soon":    30 * time.Second,
+		"edge":    time.Minute,
+		"later":   time.Hour,
+		"forever": 0,
+		"overdue": time.Second,
+	} {
+		table.Add(key, d, key)
+	}
+	clock.Skip(time.Second)
+
+	var keys []string
+	for _, item := range table.ExpiringWithin(59 * time.Second) {
+		keys = append(keys, item.Key().(string))
+	}
+	sort.Strings(keys)
+	if expected := []string{"edge", "soon"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("purge")
+	table.SetClock(clock)
+	var buf bytes.Buffer
+	table.SetLogger(log.New(&buf, "", 0))
+	for i := 0; i < 10; i++ {
+		d := time.Hour
+		if i < 7 {
+			d = time.Second
+		}
+		table.Add(i, d, i)
+	}
+	before := table.Stats().Expirations
+
+	clock.Skip(time.Minute)
+	if n := table.PurgeExpired(); n != 7 {
+		t.Errorf("expected 7 purged items, got %d", n)
+	}
+	if table.Count() != 3 {
+		t.Errorf("expected 3 items left, got %d", table.Count())
+	}
+	if n := table.Stats().Expirations - before; n != 7 {
+		t.Errorf("expected the expirations to advance by 7, got %d", n)
+	}
+	if !strings.Contains(buf.String(), "7") {
+		t.Errorf("expected the purge to be logged, got %q", buf.String())
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	table := newCacheTable("invalid")
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		t.Error("data-loader called for an invalid key")
+		return nil
+	})
+	type wrapper struct{ v interface{} }
+
+	for _, key := range []interface{}{[]int{1}, map[string]int{}, wrapper{[]byte("x")}} {
+		if _, err := table.AddChecked(key, 0, "data"); err != ErrInvalidKey {
+			t.Errorf("AddChecked(%#v): expected ErrInvalidKey, got %v", key, err)
+		}
+		if table.Add(key, 0, "data"); table.Count() != 0 {
+			t.Errorf("Add(%#v): expected the item not to be added", key)
+		}
+		if _, err := table.Value(key); err != ErrInvalidKey {
+			t.Errorf("Value(%#v): expected ErrInvalidKey, got %v", key, err)
+		}
+		if _, err := table.Delete(key); err != ErrInvalidKey {
+			t.Errorf("Delete(%#v): expected ErrInvalidKey, got %v", key, err)
+		}
+		if table.Exists(key) {
+			t.Errorf("Exists(%#v) reported an invalid key", key)
+		}
+		if _, ok := table.Get(key); ok {
+			t.Errorf("Get(%#v) found an invalid key", key)
+		}
+		// invalid keys count as missing
+		if found, err := table.ValueMany([]interface{}{key}); err == nil || len(found) != 0 {
+			t.Errorf("ValueMany(%#v): expected an error, got %v", key, found)
+		}
+	}
+	if table.Count() != 0 {
+		t.Errorf("expected an empty table, got %d items", table.Count())
+	}
+}
+
+func TestDeleteSubtree(t *testing.T) {
+	table := newCacheTable("subtree")
+	for _, key := range []string{
+		"/tenant/42",
+		"/tenant/42/users/7",
+		"/tenant/42/users/7/profile",
+		"/tenant/420",
+		"/tenant/420/users/1",
+		"/tenant/4",
+		"/tenant/42x/users",
+		"/tenant",
+	} {
+		table.Add(key, 0, key)
+	}
+	table.Add(42, 0, "not a path")
+	var deleted atomic.Int32
+	table.SetAboutToDeleteItemCallback(func(item *CacheItem) { deleted.Add(1) })
+
+	// a trailing separator makes no difference
+	if n := table.DeleteSubtree("/tenant/42/", "/"); n != 3 {
+		t.Errorf("expected 3 deleted items, got %d", n)
+	}
+	if deleted.Load() != 3 {
+		t.Errorf("expected 3 delete callbacks, got %d", deleted.Load())
+	}
+	var keys []string
+	table.Foreach(func(k interface{}, item *CacheItem) {
+		if s, ok := k.(string); ok {
+			keys = append(keys, s)
+		}
+	})
+	sort.Strings(keys)
+	expected := []string{"/tenant", "/tenant/4", "/tenant/420", "/tenant/420/users/1", "/tenant/42x/users"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v to be left, got %v", expected, keys)
+	}
+	if !table.Exists(42) {
+		t.Error("non-string key deleted")
+	}
+}
+
+func TestEvictedItemCallback(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("evicted")
+	table.SetClock(clock)
+	table.SetCapacity(2)
+
+	var mu sync.Mutex
+	reasons := make(map[interface{}]EvictReason)
+	table.SetEvictedItemCallback(func(item *CacheItem, reason EvictReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		reasons[item.Key()] = reason
+	})
+
+	table.Add("manual", time.Hour, "data")
+	table.Add("expired", time.Second, "data")
+	table.Delete("manual")
+	clock.Advance(time.Second)
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reasons) == 2
+	})
+
+	clock.Advance(time.Second)
+	table.Add("evicted", time.Hour, "data")
+	clock.Advance(time.Second)
+	table.Add("a", time.Hour, "data")
+	table.Add("b", time.Hour, "data")
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := map[interface{}]EvictReason{
+		"manual":  EvictManual,
+		"expired": EvictExpired,
+		"evicted": EvictCapacity,
+	}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("expected %v, got %v", expected, reasons)
+	}
+}
+
+func TestExpiredBatchCallback(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("batch")
+	table.SetClock(clock)
+
+	batches := make(chan []*CacheItem, 10)
+	table.SetExpiredBatchCallback(func(items []*CacheItem) {
+		if table.Count() != 1 {
+			t.Error("batch callback called before the items were deleted")
+		}
+		batches <- items
+	})
+	for i := 0; i < 5; i++ {
+		table.Add(i, time.Second, i)
+		for j := 0; j < i; j++ {
+			table.Value(i)
+		}
+	}
+	table.Add("later", time.Hour, "data")
+
+	clock.Advance(time.Second)
+	var batch []*CacheItem
+	select {
+	case batch = <-batches:
+	case <-time.After(time.Second):
+		t.Fatal("batch callback not called")
+	}
+	if len(batch) != 5 {
+		t.Fatalf("expected all 5 items in one batch, got %d", len(batch))
+	}
+	var accesses int64
+	for _, item := range batch {
+		accesses += item.AccessCount()
+	}
+	if accesses != 10 {
+		t.Errorf("expected the final access counts to sum to 10, got %d", accesses)
+	}
+	if len(batches) != 0 {
+		t.Error("expected a single batch")
+	}
+}
+
+func TestSwapValue(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("swap")
+	table.SetClock(clock)
+	r := table.Add("key", time.Minute, "old")
+	table.Value("key")
+	created, accessed := r.CreatedOn(), r.AccessedOn()
+	clock.Skip(10 * time.Second)
+
+	old, err := table.SwapValue("key", "new")
+	if err != nil || old != "old" {
+		t.Fatalf("expected the old value, got %v, %v", old, err)
+	}
+	if got, _ := table.Peek("key"); got != r || r.Data() != "new" {
+		t.Errorf("expected the item to keep its identity with the new data, got %v", got)
+	}
+	if !r.CreatedOn().Equal(created) || !r.AccessedOn().Equal(accessed) || r.LifeSpan() != time.Minute {
+		t.Error("swap touched the timestamps or the lifespan")
+	}
+
+	if old := r.Swap("newer"); old != "new" {
+		t.Errorf("expected new from the item's Swap, got %v", old)
+	}
+	if _, err := table.SwapValue("missing", "data"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestTrackAccessOff(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("untracked")
+	table.SetClock(clock)
+	table.SetTrackAccess(false)
+	table.Add("key", 10*time.Second, "value")
+
+	clock.Skip(5 * time.Second)
+	r, _ := table.Value("key")
+	if r.AccessCount() != 0 || !r.AccessedOn().Equal(r.CreatedOn()) {
+		t.Errorf("expected reads not to touch the item, got %d accesses at %v", r.AccessCount(), r.AccessedOn())
+	}
+	if remaining := r.RemainingLife(); remaining != 5*time.Second {
+		t.Errorf("expected the item to expire its lifespan after it was added, %v left", remaining)
+	}
+}
+
+// with tracking on every read locks the item to update it, which the
+// parallel readers contend on; on the single core this was measured on
+// tracked reads took 281 ns/op and untracked ones 188 ns/op
+func benchmarkTrackAccess(b *testing.B, track bool) {
+	table := newCacheTable("tracking")
+	table.SetTrackAccess(track)
+	for i := 0; i < 16; i++ {
+		table.Add(strconv.Itoa(i), time.Hour, i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			table.Value(strconv.Itoa(i & 15))
+			i++
+		}
+	})
+}
+
+func BenchmarkValueTracked(b *testing.B) {
+	benchmarkTrackAccess(b, true)
+}
+
+func BenchmarkValueUntracked(b *testing.B) {
+	benchmarkTrackAccess(b, false)
+}
+
+func TestGroupBy(t *testing.T) {
+	table := newCacheTable("groups")
+	for i := 0; i < 11; i++ {
+		table.Add(i, 0, i)
+	}
+	groups := table.GroupBy(func(item *CacheItem) string {
+		if item.Data().(int)%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	})
+
+	if len(groups) != 2 || len(groups["even"]) != 6 || len(groups["odd"]) != 5 {
+		t.Fatalf("expected 6 even and 5 odd items, got %d and %d", len(groups["even"]), len(groups["odd"]))
+	}
+	total := 0
+	for group, items := range groups {
+		for _, item := range items {
+			if parity := item.Key().(int) % 2; (group == "even") != (parity == 0) {
+				t.Errorf("item %v in the wrong group %s", item.Key(), group)
+			}
+		}
+		total += len(items)
+	}
+	if total != table.Count() {
+		t.Errorf("expected the groups to hold all %d items, got %d", table.Count(), total)
+	}
+
+	// the groups are a snapshot
+	table.Delete(0)
+	if len(groups["even"]) != 6 {
+		t.Errorf("expected the snapshot to be unchanged, got %d even items", len(groups["even"]))
+	}
+}
+
+func TestExpireAt(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("deadline")
+	table.SetClock(clock)
+	start := clock.Now()
+	table.Add("capped", 10*time.Second, "value")
+	table.Add("forever", 0, "value")
+	if err := table.ExpireAt("capped", start.Add(25*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.ExpireAt("forever", start.Add(25*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.ExpireAt("missing", start); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	// reads keep the item alive past its lifespan, but only up to the cap
+	for i := 0; i < 4; i++ {
+		clock.Advance(5 * time.Second)
+		if _, err := table.Value("capped"); err != nil {
+			t.Fatalf("expected the item to be kept alive after %v, got %v", clock.Now().Sub(start), err)
+		}
+	}
+	if r, _ := table.Peek("capped"); r.RemainingLife() != 5*time.Second {
+		t.Errorf("expected the item to have 5s left until the cap, got %v", r.RemainingLife())
+	}
+
+	clock.Advance(5 * time.Second)
+	waitFor(t, func() bool { return !table.Exists("capped") && !table.Exists("forever") })
+}
+
+func TestMaxLifeSpan(t *testing.T) {
+	table := newCacheTable("clamp")
+	table.SetMaxLifeSpan(time.Hour)
+
+	if r := table.Add("long", 24*time.Hour, "value"); r.LifeSpan() != time.Hour {
+		t.Errorf("expected the lifespan to be clamped to 1h, got %v", r.LifeSpan())
+	}
+	if r, err := table.AddChecked("checked", 24*time.Hour, "value"); err != nil || r.LifeSpan() != time.Hour {
+		t.Errorf("expected the lifespan to be clamped to 1h, got %v %v", r, err)
+	}
+	if r := table.Add("short", time.Minute, "value"); r.LifeSpan() != time.Minute {
+		t.Errorf("expected shorter lifespans to be kept, got %v", r.LifeSpan())
+	}
+	if r := table.Add("forever", 0, "value"); r.LifeSpan() != 0 {
+		t.Errorf("expected a lifespan of 0 to be kept by default, got %v", r.LifeSpan())
+	}
+	table.SetClampZeroLifeSpan(true)
+	if r := table.Add("zero", 0, "value"); r.LifeSpan() != time.Hour {
+		t.Errorf("expected a lifespan of 0 to be clamped to 1h, got %v", r.LifeSpan())
+	}
+
+	if err := table.UpdateLifeSpan("short", 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := table.Peek("short"); r.LifeSpan() != time.Hour {
+		t.Errorf("expected the updated lifespan to be clamped to 1h, got %v", r.LifeSpan())
+	}
+}
+
+func TestNegativeLifeSpan(t *testing.T) {
+	table := newCacheTable("clamp")
+	if _, err := table.AddChecked("key", -time.Second, "value"); !errors.Is(err, ErrInvalidLifeSpan) {
+		t.Errorf("expected ErrInvalidLifeSpan, got %v", err)
+	}
+	if table.Exists("key") {
+		t.Error("expected the item not to be added")
+	}
+
+	table.Add("key", time.Minute, "value")
+	if err := table.UpdateLifeSpan("key", -time.Second); !errors.Is(err, ErrInvalidLifeSpan) {
+		t.Errorf("expected ErrInvalidLifeSpan, got %v", err)
+	}
+	if r, _ := table.Peek("key"); r.LifeSpan() != time.Minute {
+		t.Errorf("expected the lifespan to be unchanged, got %v", r.LifeSpan())
+	}
+}
+
+func TestAddStream(t *testing.T) {
+	table := newCacheTable("stream")
+	ch := make(chan *CacheItem)
+	midway := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; i < 10000; i++ {
+			if i == 5000 {
+				// the stream is still open, so this only gets the lock if
+				// AddStream releases it between the batches
+				midway <- table.Count()
+			}
+			ch <- NewCacheItem(i, 0, i)
+		}
+	}()
+
+	added := make(chan int)
+	go func() {
+		added <- table.AddStream(ch)
+	}()
+	select {
+	case n := <-midway:
+		if n < 5000-streamBatchSize || n > 5000 {
+			t.Errorf("expected about 5000 items halfway through the stream, got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("table stayed locked while streaming")
+	}
+
+	if n := <-added; n != 10000 {
+		t.Errorf("expected 10000 items to be added, got %d", n)
+	}
+	if table.Count() != 10000 {
+		t.Errorf("expected 10000 items, got %d", table.Count())
+	}
+	for i := 0; i < 10000; i++ {
+		if !table.Exists(i) {
+			t.Fatalf("item %d is missing", i)
+		}
+	}
+}
+
+func TestNextCleanup(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("scheduler")
+	table.SetClock(clock)
+	start := clock.Now()
+	if _, ok := table.NextCleanup(); ok {
+		t.Error("expected no timer for an empty table")
+	}
+	table.Add("forever", 0, "value")
+	if _, ok := table.NextCleanup(); ok {
+		t.Error("expected no timer for items which never expire")
+	}
+
+	table.Add("30s", 30*time.Second, "value")
+	if next, ok := table.NextCleanup(); !ok || !next.Equal(start.Add(30*time.Second)) {
+		t.Errorf("expected the next cleanup in 30s, got %v %v", next.Sub(start), ok)
+	}
+	table.Add("10s", 10*time.Second, "value")
+	table.Add("60s", time.Minute, "value")
+	if next, ok := table.NextCleanup(); !ok || !next.Equal(start.Add(10*time.Second)) {
+		t.Errorf("expected the next cleanup in 10s, got %v %v", next.Sub(start), ok)
+	}
+	if interval := table.CleanupInterval(); interval != 10*time.Second {
+		t.Errorf("expected a cleanup interval of 10s, got %v", interval)
+	}
+
+	// once the soonest item expired the timer moves on to the next one
+	clock.Advance(10 * time.Second)
+	waitFor(t, func() bool {
+		next, ok := table.NextCleanup()
+		return ok && next.Equal(start.Add(30*time.Second))
+	})
+	if interval := table.CleanupInterval(); interval != 20*time.Second {
+		t.Errorf("expected a cleanup interval of 20s, got %v", interval)
+	}
+
+	clock.Advance(20 * time.Second)
+	waitFor(t, func() bool {
+		next, ok := table.NextCleanup()
+		return ok && next.Equal(start.Add(time.Minute))
+	})
+	clock.Advance(30 * time.Second)
+	waitFor(t, func() bool {
+		_, ok := table.NextCleanup()
+		return !ok && table.Count() == 1
+	})
+}
+
+func TestTTLJitterBulk(t *testing.T) {
+	const ttl = time.Hour
+	spread := func(table *CacheTable) int {
+		distinct := make(map[time.Duration]bool)
+		table.Foreach(func(key interface{}, item *CacheItem) {
+			distinct[item.LifeSpan()] = true
+		})
+		return len(distinct)
+	}
+
+	batch := newCacheTable("jitter")
+	batch.SetTTLJitter(0.1)
+	items := make([]*CacheItem, 100)
+	for i := range items {
+		items[i] = NewCacheItem(i, ttl, i)
+	}
+	batch.AddBatch(items)
+
+	imported := newCacheTable("jitter")
+	imported.SetTTLJitter(0.1)
+	m := make(map[interface{}]interface{})
+	for i := 0; i < 100; i++ {
+		m[i] = i
+	}
+	imported.Import(m, ttl)
+
+	streamed := newCacheTable("jitter")
+	streamed.SetTTLJitter(0.1)
+	ch := make(chan *CacheItem)
+	go func() {
+		defer close(ch)
+		for i := 0; i < 100; i++ {
+			ch <- NewCacheItem(i, ttl, i)
+		}
+	}()
+	streamed.AddStream(ch)
+
+	for _, table := range []*CacheTable{batch, imported, streamed} {
+		if n := spread(table); n < 90 {
+			t.Errorf("expected jittered lifespans, got %d distinct values", n)
+		}
+	}
+
+	// restored items keep their saved lifespan
+	var buf bytes.Buffer
+	if err := batch.SaveToWriter(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newCacheTable("jitter")
+	loaded.SetTTLJitter(0.1)
+	if err := loaded.LoadFromReader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded.Foreach(func(key interface{}, item *CacheItem) {
+		if r, _ := batch.Peek(key); item.LifeSpan() != r.LifeSpan() {
+			t.Errorf("item %v: expected the saved lifespan %v, got %v", key, r.LifeSpan(), item.LifeSpan())
+		}
+	})
+}
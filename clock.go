@@ -0,0 +1,49 @@
+package cpcache2go
+
+import "time"
+
+// Clock is the source of time used by a table, it allows to replace the real
+// time e.g. with a fake clock in tests
+type Clock interface {
+	// Now return the current time
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d has elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer created by a Clock
+type Timer interface {
+	// Stop prevents the timer from firing
+	Stop() bool
+}
+
+// realClock is the Clock backed by the time package
+type realClock struct{}
+
+// Now method for realClock
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// AfterFunc method for realClock
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SetClock configure the clock used by the table for timestamps and the
+// expiration timer. Items added to the table are switched to its clock, but
+// items created with NewCacheItem keep the real creation time. The clock
+// should be set before any items are added
+func (table *CacheTable) SetClock(c Clock) {
+	table.Lock()
+	defer table.Unlock()
+	table.clock = c
+}
+
+// now return the current time according to the table's clock, the table must
+// not be locked by the caller
+func (table *CacheTable) now() time.Time {
+	table.RLock()
+	defer table.RUnlock()
+	return table.clock.Now()
+}
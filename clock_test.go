@@ -0,0 +1,135 @@
+package cpcache2go
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when it's advanced. Timers which
+// are due fire during Advance
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// number of timers created so far
+	armed int
+}
+
+// fakeTimer is a Timer created by a fakeClock
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+// newFakeClock return a fake clock starting at a fixed time
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// Now method for fakeClock
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc method for fakeClock
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	c.armed++
+	return t
+}
+
+// Armed return how many timers were created so far
+func (c *fakeClock) Armed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.armed
+}
+
+// Advance moves the time forward by d and fires the timers which are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []func()
+	var pending []*fakeTimer
+	for _, t := range c.timers {
+		switch {
+		case t.stopped:
+		case !t.at.After(c.now):
+			t.stopped = true
+			due = append(due, t.f)
+		default:
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	for _, f := range due {
+		f()
+	}
+}
+
+// Skip moves the time forward by d without firing any timers
+func (c *fakeClock) Skip(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Stop method for fakeTimer
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+// waitFor polls cond until it's true, failing the test if it takes longer
+// than a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFakeClockExpiration(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("clock")
+	table.SetClock(clock)
+
+	r := table.Add("key", time.Minute, "data")
+	if !r.CreatedOn().Equal(clock.Now()) {
+		t.Errorf("item created at %v instead of the clock's time", r.CreatedOn())
+	}
+
+	clock.Advance(30 * time.Second)
+	if _, err := table.Value("key"); err != nil {
+		t.Fatal(err)
+	}
+	if !r.AccessedOn().Equal(clock.Now()) {
+		t.Errorf("item accessed at %v instead of the clock's time", r.AccessedOn())
+	}
+
+	// kept alive by the access above
+	clock.Advance(45 * time.Second)
+	if !table.Exists("key") {
+		t.Fatal("item expired although it was kept alive")
+	}
+
+	clock.Advance(15 * time.Second)
+	waitFor(t, func() bool { return !table.Exists("key") })
+}
@@ -0,0 +1,102 @@
+package cpcache2go
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"log/slog"
+)
+
+// compressedData is the stored form of []byte data compressed with gzip. The
+// accessors of CacheItem unwrap it, so it never leaves the package
+type compressedData struct {
+	b []byte
+	// name and loggers of the table which compressed the data, to report
+	// decompression failures without locking the table
+	table   string
+	logger  *log.Logger
+	slogger *slog.Logger
+}
+
+// SetCompression enables transparent gzip compression of []byte data larger
+// than min bytes. Data is compressed when it's stored in the table and
+// decompressed on every call of Data, trading CPU time for memory. Items
+// already in the table are not compressed, 0 disables compression
+func (table *CacheTable) SetCompression(min int) {
+	table.Lock()
+	defer table.Unlock()
+	table.compressMin = min
+}
+
+// compress data if it qualifies for compression, the method is internal and
+// requires the table lock
+func (table *CacheTable) compressInternal(data interface{}) interface{} {
+	b, ok := data.([]byte)
+	if !ok || table.compressMin <= 0 || len(b) <= table.compressMin {
+		return data
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return data
+	}
+	if err := w.Close(); err != nil {
+		return data
+	}
+	if buf.Len() >= len(b) {
+		// not worth it
+		return data
+	}
+
+	return &compressedData{
+		b:       buf.Bytes(),
+		table:   table.name,
+		logger:  table.logger,
+		slogger: table.slogger,
+	}
+}
+
+// decompress return the original data, or nil if it can't be decompressed
+func (c *compressedData) decompress() []byte {
+	r, err := gzip.NewReader(bytes.NewReader(c.b))
+	if err != nil {
+		c.logFailed(err)
+		return nil
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		c.logFailed(err)
+		return nil
+	}
+	return b
+}
+
+// log why the data could not be decompressed to the loggers of the table
+func (c *compressedData) logFailed(err error) {
+	if c.slogger != nil {
+		c.slogger.Error("Failed decompressing item data", "table", c.table, "error", err)
+		return
+	}
+	if c.logger != nil {
+		c.logger.Println("Failed decompressing item data in table", c.table+":", err)
+	}
+}
+
+// StoredSize returns how many bytes the []byte data of the item takes up in
+// the table, which is less than len(Data()) if it was compressed. Returns 0
+// for other data. Can be used as item sizer for the byte budget
+func (item *CacheItem) StoredSize() int {
+	item.RLock()
+	defer item.RUnlock()
+
+	switch d := item.data.(type) {
+	case *compressedData:
+		return len(d.b)
+	case []byte:
+		return len(d)
+	}
+
+	return 0
+}
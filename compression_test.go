@@ -0,0 +1,45 @@
+package cpcache2go
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	table := newCacheTable("compressed")
+	table.SetCompression(1024)
+	table.SetItemSizer(func(item *CacheItem) int64 { return int64(item.StoredSize()) })
+
+	payload := bytes.Repeat([]byte(`{"key":"value"},`), 10000)
+	r := table.Add("big", 0, payload)
+	small := table.Add("small", 0, []byte("tiny"))
+
+	if !bytes.Equal(r.Data().([]byte), payload) {
+		t.Fatal("data not restored")
+	}
+	got, err := table.Value("big")
+	if err != nil || !bytes.Equal(got.Data().([]byte), payload) {
+		t.Fatalf("data not restored via Value: %v", err)
+	}
+	if n := r.StoredSize(); n >= len(payload)/10 {
+		t.Errorf("expected the payload to shrink below 10%%, stored %d of %d bytes", n, len(payload))
+	}
+	if small.StoredSize() != 4 {
+		t.Errorf("expected data below the threshold to be stored as is, got %d bytes", small.StoredSize())
+	}
+	if table.Bytes() != int64(r.StoredSize()+4) {
+		t.Errorf("expected the byte budget to track the stored size, got %d", table.Bytes())
+	}
+}
+
+func TestDecompressionFailureLogged(t *testing.T) {
+	var buf bytes.Buffer
+	c := &compressedData{b: []byte("not gzip"), table: "broken", logger: log.New(&buf, "", 0)}
+	if c.decompress() != nil {
+		t.Error("expected nil for broken data")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("broken")) {
+		t.Errorf("expected the failure to be logged, got %q", buf.String())
+	}
+}
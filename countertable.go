@@ -0,0 +1,55 @@
+package cpcache2go
+
+import "time"
+
+// CounterTable is a table of int64 counters, e.g. for rate limiting. Counters
+// which weren't incremented for the lifespan of the table expire
+type CounterTable struct {
+	// the underlying table holding the counters as int64 data
+	table *CacheTable
+	// lifespan of new counters
+	lifeSpan time.Duration
+}
+
+// NewCounterTable return a new counter table whose counters expire when idle
+// for lifeSpan, 0 means they never expire. Counter tables are not registered
+// in the cache
+func NewCounterTable(name string, lifeSpan time.Duration) *CounterTable {
+	return &CounterTable{
+		table:    newCacheTable(name),
+		lifeSpan: lifeSpan,
+	}
+}
+
+// Table return the underlying table, e.g. to configure callbacks on it
+func (t *CounterTable) Table() *CacheTable {
+	return t.table
+}
+
+// Incr atomically adds delta to the counter with the given key, starting a
+// new counter at 0 if it does not exist, and returns the new value. Returns 0
+// if the counter could not be added, e.g. because the table is full
+func (t *CounterTable) Incr(key interface{}, delta int64) int64 {
+	v, err := t.table.Increment(key, delta, t.lifeSpan)
+	if err != nil {
+		t.table.logAddFailed(key, err)
+		return 0
+	}
+	return v
+}
+
+// Get return the value of the counter with the given key without keeping it
+// alive, 0 if it does not exist
+func (t *CounterTable) Get(key interface{}) int64 {
+	r, ok := t.table.Peek(key)
+	if !ok {
+		return 0
+	}
+	v, _ := r.Data().(int64)
+	return v
+}
+
+// Reset removes the counter with the given key, so it starts over at 0
+func (t *CounterTable) Reset(key interface{}) {
+	t.table.Delete(key)
+}
@@ -0,0 +1,53 @@
+package cpcache2go
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCounterTableConcurrent(t *testing.T) {
+	counters := NewCounterTable("counters", 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				counters.Incr("requests", 2)
+			}
+		}()
+	}
+	wg.Wait()
+	if v := counters.Get("requests"); v != 10000 {
+		t.Errorf("expected the counter to be 10000, got %d", v)
+	}
+
+	counters.Reset("requests")
+	if v := counters.Get("requests"); v != 0 {
+		t.Errorf("expected a reset counter to be 0, got %d", v)
+	}
+	if v := counters.Incr("requests", -1); v != -1 {
+		t.Errorf("expected a reset counter to start over, got %d", v)
+	}
+}
+
+func TestCounterTableIdleExpiry(t *testing.T) {
+	clock := newFakeClock()
+	counters := NewCounterTable("counters", 10*time.Second)
+	counters.Table().SetClock(clock)
+	counters.Incr("idle", 1)
+	counters.Incr("busy", 1)
+
+	clock.Advance(6 * time.Second)
+	counters.Incr("busy", 1)
+	clock.Advance(6 * time.Second)
+
+	waitFor(t, func() bool { return !counters.Table().Exists("idle") })
+	if v := counters.Get("idle"); v != 0 {
+		t.Errorf("expected the idle counter to have expired, got %d", v)
+	}
+	if v := counters.Get("busy"); v != 2 {
+		t.Errorf("expected the busy counter to be kept alive, got %d", v)
+	}
+}
@@ -0,0 +1,53 @@
+package cpcache2go
+
+import "sort"
+
+// Cursor pages through the items of a table in key order, taking the table
+// lock only briefly for each page. The keys are sorted once when the cursor is
+// created: items added afterwards aren't visited and items deleted in the
+// meantime are skipped, but no item is visited twice. Keys are ordered like
+// for SortByKey
+type Cursor struct {
+	table *CacheTable
+	// maximum number of items per page
+	size int
+	// sorted snapshot of the keys and the position of the next one
+	keys []interface{}
+	pos  int
+}
+
+// Cursor return a cursor visiting up to size items per call of Next
+func (table *CacheTable) Cursor(size int) *Cursor {
+	if size < 1 {
+		size = 1
+	}
+	table.RLock()
+	keys := make([]interface{}, 0, len(table.items))
+	for k := range table.items {
+		keys = append(keys, k)
+	}
+	table.RUnlock()
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return lessKey(keys[i], keys[j])
+	})
+	return &Cursor{table: table, size: size, keys: keys}
+}
+
+// Next returns the next page of items, or nil once all items were visited
+func (c *Cursor) Next() []*CacheItem {
+	page := make([]*CacheItem, 0, c.size)
+	c.table.RLock()
+	for ; c.pos < len(c.keys) && len(page) < c.size; c.pos++ {
+		if v, ok := c.table.items[c.keys[c.pos]]; ok {
+			page = append(page, v)
+		}
+	}
+	c.table.RUnlock()
+
+	if len(page) == 0 {
+		c.keys = nil
+		return nil
+	}
+	return page
+}
@@ -0,0 +1,48 @@
+package cpcache2go
+
+import "time"
+
+// SetAccessDecay enables periodically multiplying the access counts of all
+// items by factor (e.g. 0.5), so items which were popular long ago lose their
+// advantage under EvictLFU. The decay runs every interval on its own timer
+// until the table is stopped. A factor or interval of 0 disables the decay
+func (table *CacheTable) SetAccessDecay(factor float64, interval time.Duration) {
+	table.Lock()
+	defer table.Unlock()
+
+	table.decayFactor = factor
+	table.decayInterval = interval
+	table.armDecayInternal()
+}
+
+// arm the decay timer for the configured interval, the method is internal and
+// requires the table lock
+func (table *CacheTable) armDecayInternal() {
+	if table.decayTimer != nil {
+		table.decayTimer.Stop()
+		table.decayTimer = nil
+	}
+	if table.stopped || table.decayFactor <= 0 || table.decayInterval <= 0 {
+		return
+	}
+	table.decayTimer = table.clock.AfterFunc(table.decayInterval, func() {
+		go table.decayAccessCounts()
+	})
+}
+
+// decay the access counts of all items and re-arm the decay timer
+func (table *CacheTable) decayAccessCounts() {
+	table.Lock()
+	defer table.Unlock()
+	if table.stopped || table.decayFactor <= 0 {
+		return
+	}
+
+	table.log("Decaying access counts by", table.decayFactor, "in table", table.name)
+	for _, item := range table.items {
+		item.Lock()
+		item.accessCount = int64(float64(item.accessCount) * table.decayFactor)
+		item.Unlock()
+	}
+	table.armDecayInternal()
+}
@@ -0,0 +1,41 @@
+package cpcache2go
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessDecay(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("decay")
+	table.SetClock(clock)
+	table.SetCapacity(2)
+	table.SetOverflowPolicy(EvictLFU)
+	table.SetAccessDecay(0.5, time.Minute)
+
+	old := table.Add("old", time.Hour, "data")
+	for i := 0; i < 8; i++ {
+		table.Value("old")
+	}
+
+	clock.Advance(time.Minute)
+	waitFor(t, func() bool { return old.AccessCount() == 4 })
+	clock.Advance(time.Minute)
+	waitFor(t, func() bool { return old.AccessCount() == 2 })
+
+	table.Add("new", time.Hour, "data")
+	for i := 0; i < 3; i++ {
+		table.Value("new")
+	}
+	// the once hot item lost its advantage
+	table.Add("newer", time.Hour, "data")
+	if table.Exists("old") || !table.Exists("new") {
+		t.Error("expected the idle item to be evicted")
+	}
+
+	table.Stop()
+	clock.Advance(time.Minute)
+	if r, _ := table.Peek("new"); r.AccessCount() != 3 {
+		t.Errorf("access counts decayed after Stop: %d", r.AccessCount())
+	}
+}
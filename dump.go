@@ -0,0 +1,65 @@
+package cpcache2go
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// dumpRow is a snapshot of an item taken for Dump
+type dumpRow struct {
+	key         string
+	lifeSpan    time.Duration
+	age         time.Duration
+	idle        time.Duration
+	accessCount int64
+	remaining   string
+}
+
+// Dump writes a human readable overview of the table to w, e.g. for logs.
+// Every item is listed with its lifespan, age, idle time, access count and
+// remaining life, sorted by key
+func (table *CacheTable) Dump(w io.Writer) error {
+	table.RLock()
+	name := table.name
+	now := table.clock.Now()
+	rows := make([]dumpRow, 0, len(table.items))
+	for key, item := range table.items {
+		item.RLock()
+		row := dumpRow{
+			key:         fmt.Sprint(key),
+			lifeSpan:    item.lifeSpan,
+			age:         now.Sub(item.createdOn),
+			idle:        now.Sub(item.accessedOn),
+			accessCount: item.accessCount,
+			remaining:   "never",
+		}
+		if item.expiringInternal() {
+			remaining := item.expiresInternal().Sub(now)
+			if remaining < 0 {
+				remaining = 0
+			}
+			row.remaining = remaining.String()
+		}
+		item.RUnlock()
+		rows = append(rows, row)
+	}
+	table.RUnlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].key < rows[j].key
+	})
+
+	if _, err := fmt.Fprintf(w, "table %s: %d items\n", name, len(rows)); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tLIFESPAN\tAGE\tIDLE\tACCESSES\tREMAINING")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%v\t%d\t%s\n", r.key, r.lifeSpan, r.age, r.idle, r.accessCount, r.remaining)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,42 @@
+package cpcache2go
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDump(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("dumped")
+	table.SetClock(clock)
+	table.Add("session", time.Minute, "data")
+	table.Add("config", 0, "data")
+	clock.Advance(10 * time.Second)
+	table.Value("session")
+	table.Value("session")
+	clock.Advance(5 * time.Second)
+
+	var b strings.Builder
+	if err := table.Dump(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 || lines[0] != "table dumped: 2 items" {
+		t.Fatalf("unexpected dump:\n%s", b.String())
+	}
+	expected := [][]string{
+		{"KEY", "LIFESPAN", "AGE", "IDLE", "ACCESSES", "REMAINING"},
+		{"config", "0s", "15s", "15s", "0", "never"},
+		{"session", "1m0s", "15s", "5s", "2", "55s"},
+	}
+	for i, fields := range expected {
+		if got := strings.Fields(lines[i+1]); strings.Join(got, " ") != strings.Join(fields, " ") {
+			t.Errorf("line %d: expected %v, got %v", i+1, fields, got)
+		}
+	}
+	// the columns are aligned
+	if strings.Index(lines[1], "LIFESPAN") != strings.Index(lines[3], "1m0s") {
+		t.Error("columns not aligned")
+	}
+}
@@ -1,6 +1,9 @@
 package cpcache2go
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrKeyNotFound gets returned when a specific key couldn't be found
@@ -8,4 +11,62 @@ var (
 	// ErrKeyNotFoundOrLoadable gets returned when a specific key couldn't be
 	// found and loading via the data-loader callback also failed
 	ErrKeyNotFoundOrLoadable = errors.New("Key not found in cache and cloud not be loaded into cache")
+	// ErrStale gets returned along with an item which was served from the
+	// fallback table, because the data-loader could not load the key
+	ErrStale = errors.New("Key could not be loaded into cache, serving stale item from fallback table")
+	// ErrNotInt64 gets returned when a counter operation is applied to an item
+	// whose data is not an int64
+	ErrNotInt64 = errors.New("Data of the item is not an int64")
+	// ErrNilKey gets returned when adding an item with a nil key
+	ErrNilKey = errors.New("Key must not be nil")
+	// ErrInvalidKey gets returned when a key can't be compared, e.g. a slice,
+	// and so can't be stored in the table
+	ErrInvalidKey = errors.New("Key is not comparable")
+	// ErrInvalidLifeSpan gets returned when adding an item with a negative
+	// lifespan
+	ErrInvalidLifeSpan = errors.New("Lifespan must not be negative")
+	// ErrItemTooLarge gets returned when an item exceeds the byte budget of
+	// the table on its own
+	ErrItemTooLarge = errors.New("Item is larger than the byte budget of the table")
+	// ErrCacheFull gets returned when a table has no room for another item
+	// and nothing can be evicted to make room
+	ErrCacheFull = errors.New("Cache is full")
+	// ErrTableNotFound gets returned when a specific table couldn't be found
+	ErrTableNotFound = errors.New("Table not found in cache")
+	// ErrTableExists gets returned when a table name is already taken
+	ErrTableExists = errors.New("Table already exists in cache")
+	// ErrKeyCollision gets returned when two keys of a table have the same
+	// string form, e.g. 1 and "1", so the table can't be encoded as JSON
+	ErrKeyCollision = errors.New("Keys collide in their string form")
 )
+
+// KeyError gets returned by the methods operating on a single key, e.g. Value,
+// Delete or Touch, when the key couldn't be found, telling which key of which
+// table it was. It unwraps to the sentinel, so errors.Is(err, ErrKeyNotFound)
+// keeps working
+type KeyError struct {
+	// name of the table
+	Table string
+	// the key which couldn't be found
+	Key interface{}
+	// the sentinel, e.g. ErrKeyNotFound
+	Err error
+}
+
+// Error method for KeyError
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s: key %v in table %s", e.Err, e.Key, e.Table)
+}
+
+// Unwrap return the sentinel
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// keyError wraps err in a KeyError for the key, the table must not be locked
+// by the caller
+func (table *CacheTable) keyError(key interface{}, err error) error {
+	table.RLock()
+	defer table.RUnlock()
+	return &KeyError{Table: table.name, Key: key, Err: err}
+}
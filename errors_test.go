@@ -0,0 +1,52 @@
+package cpcache2go
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKeyError(t *testing.T) {
+	table := newCacheTable("errors")
+	_, valueErr := table.Value("a")
+	_, deleteErr := table.Delete("b")
+	_, replaceErr := table.Replace("c", 0, "data")
+	touchErr := table.Touch("e")
+	lifeSpanErr := table.UpdateLifeSpan("f", 0)
+	expireAtErr := table.ExpireAt("g", time.Time{})
+	_, casErr := table.CompareAndSwap("h", nil, "data", 0)
+	_, swapErr := table.SwapValue("i", "data")
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem { return nil })
+	_, loadErr := table.Value("d")
+
+	for _, tc := range []struct {
+		err      error
+		sentinel error
+		key      string
+	}{
+		{valueErr, ErrKeyNotFound, "a"},
+		{deleteErr, ErrKeyNotFound, "b"},
+		{replaceErr, ErrKeyNotFound, "c"},
+		{touchErr, ErrKeyNotFound, "e"},
+		{lifeSpanErr, ErrKeyNotFound, "f"},
+		{expireAtErr, ErrKeyNotFound, "g"},
+		{casErr, ErrKeyNotFound, "h"},
+		{swapErr, ErrKeyNotFound, "i"},
+		{loadErr, ErrKeyNotFoundOrLoadable, "d"},
+	} {
+		if !errors.Is(tc.err, tc.sentinel) {
+			t.Errorf("%v: expected errors.Is %v", tc.err, tc.sentinel)
+		}
+		var keyErr *KeyError
+		if !errors.As(tc.err, &keyErr) {
+			t.Fatalf("%v: expected a KeyError", tc.err)
+		}
+		if keyErr.Key != tc.key || keyErr.Table != "errors" {
+			t.Errorf("expected key %s in table errors, got %v in %s", tc.key, keyErr.Key, keyErr.Table)
+		}
+	}
+
+	if msg := valueErr.Error(); msg != ErrKeyNotFound.Error()+": key a in table errors" {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
@@ -0,0 +1,132 @@
+package cpcache2go
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the kind of change reported by a CacheEvent
+type EventType int
+
+const (
+	// EventAdded is sent when an item was added to the table
+	EventAdded EventType = iota
+	// EventDeleted is sent when an item was deleted from the table
+	EventDeleted
+	// EventExpired is sent when an item was removed because its lifespan ran out
+	EventExpired
+	// EventEvicted is sent when an item was removed to make room for others
+	EventEvicted
+	// EventUpdated is sent when the data or lifespan of a stored item changed
+	// in place
+	EventUpdated
+)
+
+// size of the channels returned by Watch
+const watchBufferSize = 16
+
+// CacheEvent describes a change to a table
+type CacheEvent struct {
+	Type EventType
+	Key  interface{}
+	Time time.Time
+}
+
+// Events subscribes to the changes of the table. Up to size events are
+// buffered in the returned channel, further events are dropped until the
+// subscriber catches up, so a slow subscriber never stalls the table.
+// Calling cancel stops the delivery and closes the channel
+func (table *CacheTable) Events(size int) (<-chan CacheEvent, func()) {
+	ch := make(chan CacheEvent, size)
+
+	table.Lock()
+	table.subscribers = append(table.subscribers, ch)
+	table.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			table.Lock()
+			defer table.Unlock()
+			for i, c := range table.subscribers {
+				if c == ch {
+					table.subscribers = append(table.subscribers[:i], table.subscribers[i+1:]...)
+					break
+				}
+			}
+			close(ch)
+		})
+	}
+
+	return ch, cancel
+}
+
+// WatchEvent describes a change to a watched key
+type WatchEvent = CacheEvent
+
+// Watch subscribes to the changes of a single key. Every watcher gets its own
+// copy of the events. Like with Events, a few events are buffered and further
+// events are dropped until the watcher catches up. Calling cancel stops the
+// delivery and closes the channel. The channel is closed right away if the key
+// is not comparable
+func (table *CacheTable) Watch(key interface{}) (<-chan WatchEvent, func()) {
+	key = table.normalize(key)
+	ch := make(chan WatchEvent, watchBufferSize)
+	if !validKey(key) {
+		close(ch)
+		return ch, func() {}
+	}
+
+	table.Lock()
+	if table.watchers == nil {
+		table.watchers = make(map[interface{}][]chan WatchEvent)
+	}
+	table.watchers[key] = append(table.watchers[key], ch)
+	table.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			table.Lock()
+			defer table.Unlock()
+			watchers := table.watchers[key]
+			for i, c := range watchers {
+				if c == ch {
+					watchers = append(watchers[:i], watchers[i+1:]...)
+					break
+				}
+			}
+			if len(watchers) == 0 {
+				delete(table.watchers, key)
+			} else {
+				table.watchers[key] = watchers
+			}
+			close(ch)
+		})
+	}
+
+	return ch, cancel
+}
+
+// send an event to all subscribers and watchers of key without blocking, the
+// method is internal and requires the table lock
+func (table *CacheTable) emitInternal(t EventType, key interface{}) {
+	watchers := table.watchers[key]
+	if len(table.subscribers) == 0 && len(watchers) == 0 {
+		return
+	}
+
+	e := CacheEvent{Type: t, Key: key, Time: table.clock.Now()}
+	for _, ch := range table.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	for _, ch := range watchers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
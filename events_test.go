@@ -0,0 +1,135 @@
+package cpcache2go
+
+import "testing"
+
+func TestEvents(t *testing.T) {
+	table := newCacheTable("events")
+	events, cancel := table.Events(10)
+
+	table.Add("a", 0, 1)
+	table.Add("b", 0, 2)
+	table.Delete("a")
+
+	expected := []CacheEvent{
+		{Type: EventAdded, Key: "a"},
+		{Type: EventAdded, Key: "b"},
+		{Type: EventDeleted, Key: "a"},
+	}
+	for _, e := range expected {
+		got := <-events
+		if got.Type != e.Type || got.Key != e.Key {
+			t.Fatalf("expected event %v for %v, got %v for %v", e.Type, e.Key, got.Type, got.Key)
+		}
+		if got.Time.IsZero() {
+			t.Error("event without a timestamp")
+		}
+	}
+
+	cancel()
+	table.Add("c", 0, 3)
+	if _, ok := <-events; ok {
+		t.Error("event delivered after cancel")
+	}
+	// cancel is idempotent
+	cancel()
+}
+
+func TestEventsSlowSubscriber(t *testing.T) {
+	table := newCacheTable("events")
+	events, cancel := table.Events(1)
+	defer cancel()
+
+	// doesn't block although nobody reads
+	for i := 0; i < 10; i++ {
+		table.Add(i, 0, i)
+	}
+	if e := <-events; e.Key != 0 {
+		t.Errorf("expected the first event to be buffered, got %v", e.Key)
+	}
+	if len(events) != 0 {
+		t.Error("expected further events to be dropped")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	table := newCacheTable("watch")
+	table.Add("key", 0, 0)
+	first, cancelFirst := table.Watch("key")
+	second, cancelSecond := table.Watch("key")
+	defer cancelSecond()
+
+	table.Add("other", 0, 0)
+	table.Replace("key", 0, 1)
+	table.Replace("key", 0, 2)
+
+	for _, ch := range []<-chan WatchEvent{first, second} {
+		for i := 0; i < 2; i++ {
+			e := <-ch
+			if e.Type != EventUpdated || e.Key != "key" {
+				t.Errorf("expected an update of key, got %v for %v", e.Type, e.Key)
+			}
+		}
+		if len(ch) != 0 {
+			t.Errorf("expected exactly two events, got %d more", len(ch))
+		}
+	}
+
+	cancelFirst()
+	if _, ok := <-first; ok {
+		t.Error("channel not closed by cancel")
+	}
+	table.Delete("key")
+	if e := <-second; e.Type != EventDeleted {
+		t.Errorf("expected the remaining watcher to see the delete, got %v", e.Type)
+	}
+
+	cancelSecond()
+	table.RLock()
+	_, ok := table.watchers["key"]
+	table.RUnlock()
+	if ok {
+		t.Error("watchers not unregistered")
+	}
+}
+
+func TestWatchInvalidKey(t *testing.T) {
+	table := newCacheTable("watch")
+	ch, cancel := table.Watch([]int{1})
+	if _, ok := <-ch; ok {
+		t.Error("expected a closed channel for an invalid key")
+	}
+	cancel()
+}
+
+func TestFlushNotifiesWatchers(t *testing.T) {
+	m := NewCacheManager()
+	table := m.Cache("flush")
+	table.Add("a", 0, 0)
+	table.Add("b", 0, 0)
+	events, cancel := table.Events(4)
+	defer cancel()
+	watch, cancelWatch := table.Watch("a")
+	defer cancelWatch()
+
+	table.Flush()
+	deleted := make(map[interface{}]bool)
+	for len(events) > 0 {
+		if e := <-events; e.Type == EventDeleted {
+			deleted[e.Key] = true
+		}
+	}
+	if len(deleted) != 2 || !deleted["a"] || !deleted["b"] {
+		t.Errorf("expected deletes of a and b, got %v", deleted)
+	}
+	if e := <-watch; e.Type != EventDeleted || e.Key != "a" {
+		t.Errorf("expected the watcher to see the flush, got %v for %v", e.Type, e.Key)
+	}
+
+	// dropping a table flushes it too
+	table.Add("a", 0, 0)
+	<-watch
+	m.DropTable("flush")
+	if e := <-watch; e.Type != EventDeleted {
+		t.Errorf("expected the watcher to see the drop, got %v", e.Type)
+	}
+}
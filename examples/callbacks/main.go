@@ -33,13 +33,14 @@ func main() {
 		fmt.Println("error retrieving value from cache:", err)
 	}
 
-	// bug?
-	// if registry the expire callback for a item which never be expired
+	// the expire callback only fires on expiration, so it won't
+	// be triggered for this item which never expires
 	res.SetAboutToExpireCallback(func(key interface{}) {
-		fmt.Println("Are you sure i am expire?:", key.(string))
+		fmt.Println("Never printed:", key.(string))
 	})
 
 	// deleting the item will trigger the AboutToDeleteItem callback
+	// but not the AboutToExpire callback
 	cache.Delete("someKey")
 
 	// caching a new item in cache
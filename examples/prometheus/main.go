@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"wei/cpcache2go"
+	"wei/cpcache2go/promcollector"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	cache := cpcache2go.Cache("myCache")
+
+	// produce a hit and a miss
+	cache.Add("someKey", 0, "this is data")
+	cache.Value("someKey")
+	cache.Value("missingKey")
+
+	// export the statistics of all cache tables, e.g. myapp_cache_hits_total
+	prometheus.MustRegister(promcollector.NewCollector("myapp"))
+
+	http.Handle("/metrics", promhttp.Handler())
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
@@ -0,0 +1,46 @@
+package cpcache2go
+
+// expirationQueue is a min-heap of items ordered by the deadline they were
+// queued for, it implements heap.Interface. The queue is guarded by the lock
+// of the table owning it
+type expirationQueue []*CacheItem
+
+// Len method for expirationQueue
+func (q expirationQueue) Len() int {
+	return len(q)
+}
+
+// Less method for expirationQueue
+func (q expirationQueue) Less(i, j int) bool {
+	return q[i].queuedDeadline.Before(q[j].queuedDeadline)
+}
+
+// Swap method for expirationQueue
+func (q expirationQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].queueIndex = i
+	q[j].queueIndex = j
+}
+
+// Push method for expirationQueue
+func (q *expirationQueue) Push(x interface{}) {
+	item := x.(*CacheItem)
+	item.queueIndex = len(*q)
+	*q = append(*q, item)
+}
+
+// Pop method for expirationQueue
+func (q *expirationQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.queueIndex = -1
+	*q = old[:n-1]
+	return item
+}
+
+// contains reports whether the item is currently queued
+func (q expirationQueue) contains(item *CacheItem) bool {
+	return item.queueIndex >= 0 && item.queueIndex < len(q) && q[item.queueIndex] == item
+}
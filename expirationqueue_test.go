@@ -0,0 +1,82 @@
+package cpcache2go
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+func TestExpirationQueueOrder(t *testing.T) {
+	now := time.Now()
+	var q expirationQueue
+	for _, d := range []time.Duration{3, 1, 4, 1, 5, 9, 2, 6} {
+		heap.Push(&q, &CacheItem{queuedDeadline: now.Add(d * time.Second)})
+	}
+	prev := time.Time{}
+	for q.Len() > 0 {
+		item := heap.Pop(&q).(*CacheItem)
+		if item.queuedDeadline.Before(prev) {
+			t.Fatal("items popped out of deadline order")
+		}
+		if item.queueIndex != -1 {
+			t.Error("popped item still has a queue index")
+		}
+		prev = item.queuedDeadline
+	}
+}
+
+// newExpiringTable return a table with n items, expiring one nanosecond apart
+// after a second, on a fake clock which never fires by itself
+func newExpiringTable(n int) (*CacheTable, *fakeClock) {
+	clock := newFakeClock()
+	table := newCacheTable("expiring")
+	table.SetClock(clock)
+	for i := 0; i < n; i++ {
+		table.Add(i, time.Second+time.Duration(i), i)
+	}
+
+	return table, clock
+}
+
+// A check on a table with 100k items none of which are due only looks at the
+// head of the queue, compare with BenchmarkExpirationScan100k
+func BenchmarkExpirationCheck100k(b *testing.B) {
+	table, _ := newExpiringTable(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.expirationCheck()
+	}
+}
+
+// BenchmarkExpirationScan100k is the scan over all items expirationCheck did
+// before the queue, for comparison
+func BenchmarkExpirationScan100k(b *testing.B) {
+	table, clock := newExpiringTable(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.Lock()
+		now := clock.Now()
+		smallestDuration := 0 * time.Second
+		for _, item := range table.items {
+			item.RLock()
+			remaining := item.expiresInternal().Sub(now)
+			item.RUnlock()
+			if smallestDuration == 0 || remaining < smallestDuration {
+				smallestDuration = remaining
+			}
+		}
+		table.Unlock()
+	}
+}
+
+func BenchmarkExpire100k(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		table, clock := newExpiringTable(100000)
+		clock.Skip(2 * time.Second)
+		b.StartTimer()
+		if n := table.expirationCheck(); n != 100000 {
+			b.Fatalf("expected 100000 expired items, got %d", n)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package cpcache2go
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// loaderFunc is the data-loader callback as stored by the table. The bool
+// reports whether the returned item may be cached
+type loaderFunc func(ctx context.Context, key interface{}, args ...interface{}) (*CacheItem, bool)
+
+// loadCall is a data-loader call in progress, shared by all concurrent
+// lookups of the same missing key
+type loadCall struct {
+	// closed once item and err are set
+	done chan struct{}
+	item *CacheItem
+	err  error
+}
+
+// load fetches a missing key with the data-loader. Concurrent loads of the
+// same key are coalesced, so the data-loader runs only once and all callers
+// share its result. If the context of the caller running the data-loader is
+// done, the waiting callers whose context is still live take over the load
+func (table *CacheTable) load(ctx context.Context, key interface{},
+	loadData loaderFunc, args ...interface{}) (*CacheItem, error) {
+	table.Lock()
+	if r, ok := table.items[key]; ok {
+		// loaded by someone else in the meantime
+		untracked := table.untracked
+		table.Unlock()
+		if !untracked {
+			r.KeepAlive()
+		}
+		return r, nil
+	}
+	if c, ok := table.loading[key]; ok {
+		table.Unlock()
+		select {
+		case <-c.done:
+			if ctx.Err() == nil && (errors.Is(c.err, context.Canceled) || errors.Is(c.err, context.DeadlineExceeded)) {
+				// the load was abandoned, not failed, so try again
+				return table.load(ctx, key, loadData, args...)
+			}
+			return c.item, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	c := &loadCall{done: make(chan struct{})}
+	table.loading[key] = c
+	table.Unlock()
+
+	table.runLoad(ctx, key, c, loadData, args...)
+
+	return c.item, c.err
+}
+
+// Warm loads the given keys with the data-loader ahead of time, e.g. on
+// startup, skipping keys which are already cached. Loads of keys which are
+// requested concurrently are shared as with Value. Returns how many keys were
+// loaded and the errors of the keys which could not be loaded
+func (table *CacheTable) Warm(keys []interface{}, args ...interface{}) (loaded int, errs []error) {
+	table.RLock()
+	loadData := table.loadData
+	table.RUnlock()
+
+	for _, key := range keys {
+		key = table.normalize(key)
+		if !validKey(key) {
+			errs = append(errs, ErrInvalidKey)
+			continue
+		}
+		table.RLock()
+		_, ok := table.items[key]
+		table.RUnlock()
+		if ok {
+			continue
+		}
+		if loadData == nil || table.isNegative(key) {
+			errs = append(errs, ErrKeyNotFoundOrLoadable)
+			continue
+		}
+		if _, err := table.load(context.Background(), key, loadData, args...); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		loaded++
+	}
+
+	return loaded, errs
+}
+
+// refresh reloads an existing key in the background, unless a load of the
+// key is already in progress. The current item keeps being served until the
+// data-loader returns
+func (table *CacheTable) refresh(key interface{},
+	loadData loaderFunc, args ...interface{}) {
+	table.Lock()
+	if _, ok := table.loading[key]; ok {
+		table.Unlock()
+		return
+	}
+	c := &loadCall{done: make(chan struct{})}
+	table.loading[key] = c
+	table.log("Refreshing item with key", key, "ahead of expiration in table", table.name)
+	table.Unlock()
+
+	go table.runLoad(context.Background(), key, c, loadData, args...)
+}
+
+// run the data-loader for a registered load call and release all callers
+// waiting on it, the method is internal
+func (table *CacheTable) runLoad(ctx context.Context, key interface{}, c *loadCall,
+	loadData loaderFunc, args ...interface{}) {
+	c.item, c.err = table.loadInternal(ctx, key, loadData, args...)
+
+	table.Lock()
+	delete(table.loading, key)
+	table.Unlock()
+	close(c.done)
+}
+
+// run the data-loader and add its result to the cache, the method is internal
+func (table *CacheTable) loadInternal(ctx context.Context, key interface{},
+	loadData loaderFunc, args ...interface{}) (_ *CacheItem, err error) {
+	table.RLock()
+	tracer := table.loaderTracer
+	attempts, delay := table.loaderAttempts, table.loaderRetryDelay
+	clock := table.clock
+	table.RUnlock()
+	if tracer != nil {
+		var end func(err error)
+		ctx, end = tracer(ctx, key)
+		defer func() { end(err) }()
+	}
+
+	item, store := loadData(ctx, key, args...)
+	for i := 1; item == nil && i < attempts; i++ {
+		if err := sleep(ctx, clock, delay<<(i-1)); err != nil {
+			return nil, err
+		}
+		table.log("Retrying to load item with key", key, "into table", table.name)
+		item, store = loadData(ctx, key, args...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if item != nil {
+		table.stats.loaderSuccesses.Add(1)
+		if store {
+			table.addLoaded(key, item)
+		}
+		return item, nil
+	}
+	table.stats.loaderFailures.Add(1)
+	table.addNegative(key)
+
+	return nil, ErrKeyNotFoundOrLoadable
+}
+
+// sleep waits for d on the given clock, returning early with the context's
+// error if it's done first
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	elapsed := make(chan struct{})
+	t := clock.AfterFunc(d, func() { close(elapsed) })
+	defer t.Stop()
+
+	select {
+	case <-elapsed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run the batch data-loader for the missing keys and add its results to the
+// cache, the method is internal
+func (table *CacheTable) loadBatchInternal(keys []interface{},
+	loadBatch func(keys []interface{}) map[interface{}]*CacheItem) map[interface{}]*CacheItem {
+	items := loadBatch(keys)
+	r := make(map[interface{}]*CacheItem, len(items))
+	for _, key := range keys {
+		item, ok := items[key]
+		if !ok || item == nil {
+			table.stats.loaderFailures.Add(1)
+			table.addNegative(key)
+			continue
+		}
+		table.stats.loaderSuccesses.Add(1)
+		r[key] = table.addLoaded(key, item)
+	}
+
+	return r
+}
+
+// add an item returned by a data-loader to the cache, the method is internal
+func (table *CacheTable) addLoaded(key interface{}, item *CacheItem) *CacheItem {
+	// the item came from the backing store, so no write-through
+	table.Lock()
+	loaded := newCacheItem(table.clock, key, item.lifeSpan, item.data)
+	// cache value so we don't keep blocking the mutex
+	loadedItem := table.loadedItem
+	if err := table.addInternal(loaded, false); err != nil {
+		table.logAddFailed(key, err)
+	} else if loadedItem != nil {
+		table.safeCall(func() { loadedItem(loaded) })
+	}
+
+	return loaded
+}
+
+// SetLoaderTracer configure a hook, which is called before every call of the
+// data-loader with the key, e.g. to start a tracing span. The context it
+// returns is passed on to the data-loader and the function it returns is
+// called with the resulting error, e.g. ErrKeyNotFoundOrLoadable, once the
+// data-loader is done
+func (table *CacheTable) SetLoaderTracer(f func(ctx context.Context, key interface{}) (context.Context, func(err error))) {
+	table.Lock()
+	defer table.Unlock()
+	table.loaderTracer = f
+}
+
+// SetLoaderRetry configure how often the data-loader callback is tried for a
+// key before the load fails with ErrKeyNotFoundOrLoadable. The delay between
+// the tries starts at baseDelay and doubles after each try, a cancelled
+// context stops the retries. Attempts of 1 or less disable retries
+func (table *CacheTable) SetLoaderRetry(attempts int, baseDelay time.Duration) {
+	table.Lock()
+	defer table.Unlock()
+	table.loaderAttempts = attempts
+	table.loaderRetryDelay = baseDelay
+}
+
+// SetNegativeCacheTTL configure how long keys the data-loader callback could
+// not load are remembered as absent. Until then, Value returns
+// ErrKeyNotFoundOrLoadable for such keys without calling the data-loader
+// again. Absent keys are not items, so Exists, Count etc. don't see them.
+// A duration of 0 disables negative caching
+func (table *CacheTable) SetNegativeCacheTTL(d time.Duration) {
+	table.Lock()
+	defer table.Unlock()
+	table.negativeTTL = d
+}
+
+// isNegative reports whether the key is currently known to be absent
+func (table *CacheTable) isNegative(key interface{}) bool {
+	table.RLock()
+	defer table.RUnlock()
+	deadline, ok := table.negative[key]
+
+	return ok && table.clock.Now().Before(deadline)
+}
+
+// remember the key as absent if negative caching is enabled
+func (table *CacheTable) addNegative(key interface{}) {
+	table.Lock()
+	defer table.Unlock()
+	if table.negativeTTL <= 0 {
+		return
+	}
+
+	now := table.clock.Now()
+	table.negative[key] = now.Add(table.negativeTTL)
+
+	// sweep expired entries whenever the map doubled since the last sweep
+	if len(table.negative) >= table.negativeSweepAt {
+		for k, deadline := range table.negative {
+			if !now.Before(deadline) {
+				delete(table.negative, k)
+			}
+		}
+		table.negativeSweepAt = 2*len(table.negative) + 16
+	}
+}
@@ -0,0 +1,443 @@
+package cpcache2go
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValueContextCancelled(t *testing.T) {
+	table := newCacheTable("loader")
+	started := make(chan struct{})
+	table.SetDataLoaderContext(func(ctx context.Context, key interface{}, args ...interface{}) *CacheItem {
+		close(started)
+		<-ctx.Done()
+		// a partial result which must not be cached
+		return NewCacheItem(key, 0, "partial")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	r, err := table.ValueContext(ctx, "key")
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v, %v", r, err)
+	}
+	if table.Exists("key") {
+		t.Error("item of a cancelled load was cached")
+	}
+}
+
+func TestLoaderSingleflight(t *testing.T) {
+	table := newCacheTable("loader")
+	var calls atomic.Int32
+	release := make(chan struct{})
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		calls.Add(1)
+		<-release
+		return NewCacheItem(key, 0, "loaded")
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := table.Value("key")
+			if err != nil || r.Data() != "loaded" {
+				t.Errorf("expected the loaded item, got %v, %v", r, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the loader to run once, ran %d times", n)
+	}
+}
+
+func TestLoaderTakeover(t *testing.T) {
+	table := newCacheTable("loader")
+	started := make(chan struct{}, 1)
+	table.SetDataLoaderContext(func(ctx context.Context, key interface{}, args ...interface{}) *CacheItem {
+		started <- struct{}{}
+		if ctx.Done() != nil {
+			// the leader, which gets cancelled
+			<-ctx.Done()
+			return nil
+		}
+		return NewCacheItem(key, 0, "loaded")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err := table.ValueContext(ctx, "key")
+		leader <- err
+	}()
+	<-started
+
+	waiter := make(chan *CacheItem)
+	go func() {
+		r, err := table.Value("key")
+		if err != nil {
+			t.Errorf("waiter failed: %v", err)
+		}
+		waiter <- r
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	if err := <-leader; err != context.Canceled {
+		t.Errorf("expected context.Canceled for the leader, got %v", err)
+	}
+	<-started
+	if r := <-waiter; r == nil || r.Data() != "loaded" {
+		t.Errorf("expected the waiter to load the item, got %v", r)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("loader")
+	table.SetClock(clock)
+	table.SetRefreshAhead(0.1)
+	loaded := make(chan struct{})
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		defer close(loaded)
+		return NewCacheItem(key, 10*time.Second, "fresh")
+	})
+	table.Add("key", 10*time.Second, "stale")
+
+	// not close enough to expiring yet
+	clock.Skip(5 * time.Second)
+	if r, _ := table.Value("key"); r.Data() != "stale" {
+		t.Fatalf("expected the stale item, got %v", r.Data())
+	}
+	clock.Skip(9500 * time.Millisecond)
+	if r, _ := table.Value("key"); r.Data() != "stale" {
+		t.Fatalf("expected the stale item to be served while refreshing, got %v", r.Data())
+	}
+
+	select {
+	case <-loaded:
+	case <-time.After(time.Second):
+		t.Fatal("item was not refreshed")
+	}
+	waitFor(t, func() bool {
+		r, _ := table.Peek("key")
+		return r.Data() == "fresh"
+	})
+}
+
+func TestLoadedItemCallback(t *testing.T) {
+	table := newCacheTable("loader")
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		return NewCacheItem(key, 0, "loaded")
+	})
+	var loaded, added []interface{}
+	table.SetLoadedItemCallback(func(item *CacheItem) {
+		// outside the lock, so the table can be used
+		if !table.Exists(item.Key()) {
+			t.Error("loaded callback called before the item was added")
+		}
+		loaded = append(loaded, item.Key())
+	})
+	table.SetAddedItemCallback(func(item *CacheItem) {
+		added = append(added, item.Key())
+	})
+
+	if _, err := table.Value("key"); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || len(added) != 1 {
+		t.Fatalf("expected both callbacks to fire once, got loaded %v and added %v", loaded, added)
+	}
+
+	// a hit fires neither
+	table.Value("key")
+	if len(loaded) != 1 || len(added) != 1 {
+		t.Errorf("callbacks fired on a cache hit, got loaded %v and added %v", loaded, added)
+	}
+
+	// a plain add only fires the added callback
+	table.Add("other", 0, "data")
+	if len(loaded) != 1 || len(added) != 2 {
+		t.Errorf("expected only the added callback for Add, got loaded %v and added %v", loaded, added)
+	}
+}
+
+func TestBatchDataLoader(t *testing.T) {
+	table := newCacheTable("loader")
+	table.Add("a", 0, "a")
+	table.Add("b", 0, "b")
+	var calls [][]interface{}
+	table.SetBatchDataLoader(func(keys []interface{}) map[interface{}]*CacheItem {
+		calls = append(calls, keys)
+		return map[interface{}]*CacheItem{"x": NewCacheItem("x", 0, "loaded")}
+	})
+
+	found, err := table.ValueMany([]interface{}{"a", "x", "b", "y", "x"})
+	if len(calls) != 1 || !reflect.DeepEqual(calls[0], []interface{}{"x", "y"}) {
+		t.Fatalf("expected a single call with the missing keys, got %v", calls)
+	}
+	if !errors.Is(err, ErrKeyNotFoundOrLoadable) {
+		t.Errorf("expected ErrKeyNotFoundOrLoadable for y, got %v", err)
+	}
+	if len(found) != 3 || found["x"] == nil || found["x"].Data() != "loaded" {
+		t.Errorf("expected a, b and the loaded x, got %v", found)
+	}
+	if !table.Exists("x") {
+		t.Error("loaded item was not cached")
+	}
+
+	// everything cached now but y
+	calls = nil
+	if _, err := table.ValueMany([]interface{}{"a", "x"}); err != nil || len(calls) != 0 {
+		t.Errorf("expected only hits, got %v and calls %v", err, calls)
+	}
+}
+
+func TestDataLoaderDontCache(t *testing.T) {
+	table := newCacheTable("loader")
+	degraded := true
+	table.SetDataLoaderEx(func(key interface{}, args ...interface{}) (*CacheItem, bool) {
+		return NewCacheItem(key, 0, "value"), !degraded
+	})
+
+	r, err := table.Value("key")
+	if err != nil || r.Data() != "value" {
+		t.Fatalf("expected the loaded item, got %v, %v", r, err)
+	}
+	if table.Exists("key") {
+		t.Error("item cached although the loader said not to")
+	}
+
+	degraded = false
+	table.Value("key")
+	if !table.Exists("key") {
+		t.Error("item not cached")
+	}
+}
+
+func TestFallbackTable(t *testing.T) {
+	table := newCacheTable("primary")
+	fallback := newCacheTable("fallback")
+	table.SetFallbackTable(fallback)
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		return nil
+	})
+	fallback.Add("key", 0, "stale")
+
+	r, err := table.Value("key")
+	if err != ErrStale || r == nil || r.Data() != "stale" {
+		t.Fatalf("expected the stale item with ErrStale, got %v, %v", r, err)
+	}
+	if table.Exists("key") {
+		t.Error("stale item was copied into the primary table")
+	}
+
+	if _, err := table.Value("missing"); !errors.Is(err, ErrKeyNotFoundOrLoadable) {
+		t.Errorf("expected ErrKeyNotFoundOrLoadable, got %v", err)
+	}
+}
+
+func TestLoaderTracer(t *testing.T) {
+	table := newCacheTable("loader")
+	type spanKey struct{}
+	var started []interface{}
+	var ended []error
+	table.SetLoaderTracer(func(ctx context.Context, key interface{}) (context.Context, func(err error)) {
+		started = append(started, key)
+		return context.WithValue(ctx, spanKey{}, key), func(err error) {
+			ended = append(ended, err)
+		}
+	})
+	table.SetDataLoaderContext(func(ctx context.Context, key interface{}, args ...interface{}) *CacheItem {
+		if ctx.Value(spanKey{}) != key {
+			t.Error("loader didn't get the tracer's context")
+		}
+		if key == "missing" {
+			return nil
+		}
+		return NewCacheItem(key, 0, "loaded")
+	})
+
+	table.ValueContext(context.Background(), "key")
+	table.ValueContext(context.Background(), "missing")
+	// a hit isn't traced
+	table.ValueContext(context.Background(), "key")
+
+	if !reflect.DeepEqual(started, []interface{}{"key", "missing"}) {
+		t.Errorf("expected spans for key and missing, got %v", started)
+	}
+	if len(ended) != 2 || ended[0] != nil || ended[1] != ErrKeyNotFoundOrLoadable {
+		t.Errorf("expected the spans to end with nil and ErrKeyNotFoundOrLoadable, got %v", ended)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	table := newCacheTable("loader")
+	var mu sync.Mutex
+	var calls []interface{}
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		mu.Lock()
+		calls = append(calls, key)
+		mu.Unlock()
+		if key == "broken" {
+			return nil
+		}
+		return NewCacheItem(key, 0, "loaded")
+	})
+	table.Add("cached", 0, "data")
+
+	loaded, errs := table.Warm([]interface{}{"a", "b", "cached", "broken", []int{1}})
+	if loaded != 2 {
+		t.Errorf("expected 2 loaded keys, got %d", loaded)
+	}
+	if len(errs) != 2 || !errors.Is(errs[0], ErrKeyNotFoundOrLoadable) || errs[1] != ErrInvalidKey {
+		t.Errorf("expected errors for broken and the invalid key, got %v", errs)
+	}
+	for _, key := range []string{"a", "b", "cached"} {
+		if !table.Exists(key) {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+	if !reflect.DeepEqual(calls, []interface{}{"a", "b", "broken"}) {
+		t.Errorf("expected the loader to skip cached keys, got calls for %v", calls)
+	}
+}
+
+func TestLoaderRetry(t *testing.T) {
+	table := newCacheTable("loader")
+	table.SetLoaderRetry(3, time.Millisecond)
+	var calls atomic.Int32
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		if calls.Add(1) < 3 {
+			return nil
+		}
+		return NewCacheItem(key, 0, "loaded")
+	})
+
+	r, err := table.Value("key")
+	if err != nil || r.Data() != "loaded" {
+		t.Fatalf("expected the item after retrying, got %v, %v", r, err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("expected 3 loader calls, got %d", n)
+	}
+	if !table.Exists("key") {
+		t.Error("item not cached")
+	}
+}
+
+func TestLoaderRetryCancelled(t *testing.T) {
+	table := newCacheTable("loader")
+	table.SetLoaderRetry(5, time.Hour)
+	var calls atomic.Int32
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		calls.Add(1)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := table.ValueContext(ctx, "key"); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the retries to stop with the context, got %d calls", n)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("loader")
+	table.SetClock(clock)
+	table.SetLazyExpiration(true)
+	table.SetStaleWhileRevalidate(time.Minute)
+	release := make(chan struct{})
+	table.SetDataLoader(func(key interface{}, args ...interface{}) *CacheItem {
+		if key != "key" {
+			return nil
+		}
+		<-release
+		return NewCacheItem(key, 10*time.Second, "fresh")
+	})
+	table.Add("key", 10*time.Second, "stale")
+	table.Add("gone", 10*time.Second, "stale")
+	clock.Skip(15 * time.Second)
+
+	// the loader blocks until released, so the stale hit must not wait on it
+	served := make(chan *CacheItem)
+	go func() {
+		r, _ := table.Value("key")
+		served <- r
+	}()
+	select {
+	case r := <-served:
+		if r.Data() != "stale" {
+			t.Fatalf("expected the stale item, got %v", r.Data())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stale hit blocked on the reload")
+	}
+	close(release)
+	waitFor(t, func() bool {
+		r, _ := table.Peek("key")
+		return r != nil && r.Data() == "fresh"
+	})
+
+	// the reload of this one fails, so it's served until the window ran out
+	if r, err := table.Value("gone"); err != nil || r.Data() != "stale" {
+		t.Fatalf("expected the stale item within the window, got %v %v", r, err)
+	}
+	clock.Skip(time.Minute)
+	if _, err := table.Value("gone"); err == nil {
+		t.Error("expected the item to be expired after the window")
+	}
+	if table.Exists("gone") {
+		t.Error("expected the item to be removed after the window")
+	}
+}
+
+func TestStaleItemsNotKeptAlive(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("loader")
+	table.SetClock(clock)
+	table.SetLazyExpiration(true)
+	table.SetStaleWhileRevalidate(time.Minute)
+	for _, key := range []string{"get", "many", "touch", "touchmany"} {
+		table.Add(key, 10*time.Second, "stale")
+	}
+	clock.Skip(15 * time.Second)
+
+	table.Get("get")
+	table.GetMany([]interface{}{"many"})
+	table.Touch("touch")
+	if n := table.TouchMany([]interface{}{"touchmany"}); n != 1 {
+		t.Errorf("expected the stale item to be found, got %d", n)
+	}
+	for _, key := range []string{"get", "many", "touch", "touchmany"} {
+		r, _ := table.Peek(key)
+		if r.AccessCount() != 0 || r.RemainingLife() != 0 {
+			t.Errorf("%s: expected the stale item not to be kept alive, %d accesses and %v left", key, r.AccessCount(), r.RemainingLife())
+		}
+	}
+
+	// fresh items are still kept alive
+	table.Add("fresh", 10*time.Second, "fresh")
+	clock.Skip(5 * time.Second)
+	table.Get("fresh")
+	if r, _ := table.Peek("fresh"); r.RemainingLife() != 10*time.Second {
+		t.Errorf("expected the fresh item to be kept alive, %v left", r.RemainingLife())
+	}
+}
@@ -0,0 +1,95 @@
+package cpcache2go
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// itemOverhead is the approximate memory taken up by an item apart from its
+// key and data: the CacheItem struct and its entry in the items map
+const itemOverhead = int64(unsafe.Sizeof(CacheItem{})) + 48
+
+// estimateDepth limits how deep estimateSize follows pointers, so cyclic data
+// can't recurse forever
+const estimateDepth = 8
+
+// MemoryUsage return an approximate number of bytes taken up by the table.
+// Items are measured with the item sizer if one is set, otherwise their key
+// and data are estimated by walking common types via reflection. Meant for
+// diagnostics, the result is not exact
+func (table *CacheTable) MemoryUsage() int64 {
+	table.RLock()
+	defer table.RUnlock()
+
+	total := int64(len(table.items)) * itemOverhead
+	if table.sizer != nil {
+		return total + table.bytes
+	}
+
+	for k, v := range table.items {
+		v.RLock()
+		data := v.data
+		v.RUnlock()
+		total += estimateSize(reflect.ValueOf(k), estimateDepth)
+		if c, ok := data.(*compressedData); ok {
+			total += int64(len(c.b))
+			continue
+		}
+		total += estimateSize(reflect.ValueOf(data), estimateDepth)
+	}
+
+	return total
+}
+
+// estimateSize return the approximate number of bytes referenced by v in
+// addition to its own size
+func estimateSize(v reflect.Value, depth int) int64 {
+	if !v.IsValid() || depth == 0 {
+		return 0
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() {
+			return 0
+		}
+		n := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			n += estimateSize(v.Index(i), depth-1)
+		}
+		return n
+	case reflect.Array:
+		var n int64
+		for i := 0; i < v.Len(); i++ {
+			n += estimateSize(v.Index(i), depth-1)
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() {
+			return 0
+		}
+		// keys, values and roughly one tophash byte and pointer per entry
+		n := int64(v.Len()) * int64(v.Type().Key().Size()+v.Type().Elem().Size()+9)
+		iter := v.MapRange()
+		for iter.Next() {
+			n += estimateSize(iter.Key(), depth-1) + estimateSize(iter.Value(), depth-1)
+		}
+		return n
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		e := v.Elem()
+		return int64(e.Type().Size()) + estimateSize(e, depth-1)
+	case reflect.Struct:
+		var n int64
+		for i := 0; i < v.NumField(); i++ {
+			n += estimateSize(v.Field(i), depth-1)
+		}
+		return n
+	}
+
+	return 0
+}
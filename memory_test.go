@@ -0,0 +1,55 @@
+package cpcache2go
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	table := newCacheTable("memory")
+	const n, size = 100, 4096
+	for i := 0; i < n; i++ {
+		table.Add(i, 0, make([]byte, size))
+	}
+
+	payload := int64(n * size)
+	usage := table.MemoryUsage()
+	if usage < payload || usage > payload*12/10 {
+		t.Errorf("expected the usage to be within 20%% above %d bytes, got %d", payload, usage)
+	}
+	if usage-payload < n*itemOverhead {
+		t.Errorf("expected the per-item overhead to be included, got %d bytes on top", usage-payload)
+	}
+
+	// with a sizer, its sizes are used instead of the estimate
+	sized := newCacheTable("sized")
+	sized.SetItemSizer(func(item *CacheItem) int64 { return 10 })
+	sized.Add("key", 0, make([]byte, size))
+	if usage := sized.MemoryUsage(); usage != 10+itemOverhead {
+		t.Errorf("expected %d bytes, got %d", 10+itemOverhead, usage)
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	type node struct {
+		name string
+		next *node
+	}
+	cyclic := &node{name: "loop"}
+	cyclic.next = cyclic
+
+	for _, tc := range []struct {
+		v        interface{}
+		min, max int64
+	}{
+		{"hello", 5, 5},
+		{[]byte("hello"), 5, 8},
+		{[]string{"ab", "cd"}, 36, 36},
+		{map[string]int{"a": 1}, 1, 64},
+		{cyclic, 4, 1024},
+	} {
+		if n := estimateSize(reflect.ValueOf(tc.v), estimateDepth); n < tc.min || n > tc.max {
+			t.Errorf("%#v: expected %d to %d bytes, got %d", tc.v, tc.min, tc.max, n)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package cpcache2go
+
+import "container/list"
+
+// SetPreserveOrder enables keeping track of the order in which keys were
+// added, so Foreach, ForeachUntil, Keys, Values and Items visit the items in
+// insertion order. Overwriting a key keeps its original position. Costs a
+// little extra work per add and delete. Items already in the table are put in
+// no particular order when enabling it
+func (table *CacheTable) SetPreserveOrder(preserve bool) {
+	table.Lock()
+	defer table.Unlock()
+
+	if !preserve {
+		table.order = nil
+		table.orderIndex = nil
+		return
+	}
+	if table.order != nil {
+		return
+	}
+	table.order = list.New()
+	table.orderIndex = make(map[interface{}]*list.Element, len(table.items))
+	for k := range table.items {
+		table.orderIndex[k] = table.order.PushBack(k)
+	}
+}
+
+// append the key to the insertion order unless it's already in there, the
+// method is internal and requires the table lock
+func (table *CacheTable) orderInternal(key interface{}) {
+	if table.order == nil {
+		return
+	}
+	if _, ok := table.orderIndex[key]; !ok {
+		table.orderIndex[key] = table.order.PushBack(key)
+	}
+}
+
+// drop the key from the insertion order, the method is internal and requires
+// the table lock
+func (table *CacheTable) unorderInternal(key interface{}) {
+	if table.order == nil {
+		return
+	}
+	if e, ok := table.orderIndex[key]; ok {
+		table.order.Remove(e)
+		delete(table.orderIndex, key)
+	}
+}
+
+// visit the items in insertion order if it's preserved, in no particular
+// order otherwise, until f returns false. The method is internal and requires
+// the table lock
+func (table *CacheTable) rangeInternal(f func(k interface{}, item *CacheItem) bool) {
+	if table.order == nil {
+		for k, v := range table.items {
+			if !f(k, v) {
+				return
+			}
+		}
+		return
+	}
+	for e := table.order.Front(); e != nil; e = e.Next() {
+		if !f(e.Value, table.items[e.Value]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package cpcache2go
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreserveOrder(t *testing.T) {
+	table := newCacheTable("ordered")
+	table.SetPreserveOrder(true)
+	for _, key := range []string{"c", "a", "d", "b", "e"} {
+		table.Add(key, 0, key)
+	}
+	table.Delete("d")
+	// overwriting keeps the original position
+	table.Add("c", 0, "C")
+	table.Add("f", 0, "f")
+
+	expected := []interface{}{"c", "a", "b", "e", "f"}
+	var visited []interface{}
+	table.Foreach(func(k interface{}, item *CacheItem) {
+		visited = append(visited, k)
+	})
+	if !reflect.DeepEqual(visited, expected) {
+		t.Errorf("Foreach: expected %v, got %v", expected, visited)
+	}
+	if keys := table.Keys(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Keys: expected %v, got %v", expected, keys)
+	}
+	if values := table.Values(); !reflect.DeepEqual(values, []interface{}{"C", "a", "b", "e", "f"}) {
+		t.Errorf("Values: expected insertion order, got %v", values)
+	}
+}
@@ -0,0 +1,211 @@
+package cpcache2go
+
+import (
+	"container/heap"
+	"time"
+)
+
+// OverflowPolicy selects what happens when adding an item takes a table over
+// its capacity or byte budget
+type OverflowPolicy int
+
+const (
+	// EvictLRU evicts the least recently accessed items
+	EvictLRU OverflowPolicy = iota
+	// EvictLFU evicts the least frequently accessed items, ties are broken
+	// by the least recent access
+	EvictLFU
+	// Reject keeps all items and fails the add with ErrCacheFull. The evicting
+	// policies fail with ErrCacheFull too if nothing evictable is left
+	Reject
+)
+
+// SetCapacity configure the maximum number of items in the table. When adding
+// an item takes the table over capacity, items are evicted or the add is
+// rejected depending on the overflow policy. Pinned items and, by default,
+// items which never expire are not evicted. 0 disables the limit. Lowering
+// the capacity takes effect on the next add
+func (table *CacheTable) SetCapacity(n int) {
+	table.Lock()
+	defer table.Unlock()
+	table.capacity = n
+}
+
+// SetOverflowPolicy configure what happens when the table runs over its
+// capacity or byte budget, the default is EvictLRU
+func (table *CacheTable) SetOverflowPolicy(p OverflowPolicy) {
+	table.Lock()
+	defer table.Unlock()
+	table.overflowPolicy = p
+}
+
+// SetZeroLifeSpanEvictable configure whether items which never expire may be
+// evicted when the table runs over its capacity or byte budget. By default
+// they are treated like pinned items and never evicted
+func (table *CacheTable) SetZeroLifeSpanEvictable(evictable bool) {
+	table.Lock()
+	defer table.Unlock()
+	table.zeroLifeSpanEvictable = evictable
+}
+
+// SetEvictionSampleSize configure approximate eviction: instead of scanning
+// all items for the best victim, only k items picked at random are compared.
+// This keeps evictions cheap for big tables at the cost of accuracy. 0 disables
+// sampling
+func (table *CacheTable) SetEvictionSampleSize(k int) {
+	table.Lock()
+	defer table.Unlock()
+	table.evictionSampleSize = k
+}
+
+// reports whether the table is over its capacity or byte budget, the method
+// is internal and requires the table lock
+func (table *CacheTable) overflowInternal() bool {
+	return (table.capacity > 0 && len(table.items) > table.capacity) ||
+		(table.maxBytes > 0 && table.bytes > table.maxBytes)
+}
+
+// reports whether item can be added without taking the table over its
+// capacity or byte budget, the method is internal and requires the table lock.
+// If evict is set, evictable items count as room
+func (table *CacheTable) fitsInternal(item *CacheItem, evict bool) bool {
+	old := table.items[item.key]
+	count, bytes := len(table.items)+1, table.bytes+table.sizeInternal(item)
+	if old != nil {
+		count--
+		bytes -= old.size
+	}
+	fits := func() bool {
+		return (table.capacity <= 0 || count <= table.capacity) &&
+			(table.maxBytes <= 0 || bytes <= table.maxBytes)
+	}
+	if fits() || !evict {
+		return fits()
+	}
+
+	for _, v := range table.items {
+		if v != old && table.evictableInternal(v) {
+			count--
+			bytes -= v.size
+			if fits() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// victimInternal picks the item to evict next according to the overflow
+// policy among all or a sample of the items, the method is internal and
+// requires the table lock. The item keep and items already being deleted are
+// never picked
+func (table *CacheTable) victimInternal(keep *CacheItem) *CacheItem {
+	var victim *CacheItem
+	var victimCount int64
+	var victimAccessedOn time.Time
+	sampled := 0
+	// map iteration starts at a random position, which is good enough for
+	// sampling
+	for _, item := range table.items {
+		if table.evictionSampleSize > 0 && sampled >= table.evictionSampleSize {
+			break
+		}
+		if item == keep || item.removing {
+			continue
+		}
+		if !table.evictableInternal(item) {
+			continue
+		}
+		sampled++
+		item.RLock()
+		count, accessedOn := item.accessCount, item.accessedOn
+		item.RUnlock()
+
+		better := victim == nil
+		if !better && table.overflowPolicy == EvictLFU && count != victimCount {
+			better = count < victimCount
+		} else if !better {
+			better = accessedOn.Before(victimAccessedOn)
+		}
+		if better {
+			victim, victimCount, victimAccessedOn = item, count, accessedOn
+		}
+	}
+
+	return victim
+}
+
+// victimCandidate is an item which may be evicted, along with a snapshot of
+// the access statistics it is ranked by
+type victimCandidate struct {
+	item       *CacheItem
+	count      int64
+	accessedOn time.Time
+}
+
+// victimHeap is a min-heap of eviction candidates with the best victim on top
+// according to the overflow policy, it implements heap.Interface
+type victimHeap struct {
+	candidates []victimCandidate
+	lfu        bool
+}
+
+// Len method for victimHeap
+func (h *victimHeap) Len() int {
+	return len(h.candidates)
+}
+
+// Less method for victimHeap
+func (h *victimHeap) Less(i, j int) bool {
+	a, b := h.candidates[i], h.candidates[j]
+	if h.lfu && a.count != b.count {
+		return a.count < b.count
+	}
+	return a.accessedOn.Before(b.accessedOn)
+}
+
+// Swap method for victimHeap
+func (h *victimHeap) Swap(i, j int) {
+	h.candidates[i], h.candidates[j] = h.candidates[j], h.candidates[i]
+}
+
+// Push method for victimHeap
+func (h *victimHeap) Push(x interface{}) {
+	h.candidates = append(h.candidates, x.(victimCandidate))
+}
+
+// Pop method for victimHeap
+func (h *victimHeap) Pop() interface{} {
+	old := h.candidates
+	n := len(old)
+	c := old[n-1]
+	h.candidates = old[:n-1]
+	return c
+}
+
+// victimsInternal ranks all items which may be evicted according to the
+// overflow policy, the method is internal and requires the table lock. The
+// item keep and items already being deleted are left out
+func (table *CacheTable) victimsInternal(keep *CacheItem) *victimHeap {
+	h := &victimHeap{lfu: table.overflowPolicy == EvictLFU}
+	for _, item := range table.items {
+		if item == keep || item.removing || !table.evictableInternal(item) {
+			continue
+		}
+		item.RLock()
+		h.candidates = append(h.candidates, victimCandidate{item, item.accessCount, item.accessedOn})
+		item.RUnlock()
+	}
+	heap.Init(h)
+
+	return h
+}
+
+// reports whether item may be evicted, which pinned and borrowed items may
+// not, the method is internal and requires the table lock
+func (table *CacheTable) evictableInternal(item *CacheItem) bool {
+	item.RLock()
+	defer item.RUnlock()
+	return !item.pinned && item.borrows == 0 && (item.lifeSpan > 0 || table.zeroLifeSpanEvictable)
+}
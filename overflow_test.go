@@ -0,0 +1,411 @@
+package cpcache2go
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaxBytes(t *testing.T) {
+	table := newCacheTable("bytes")
+	table.SetItemSizer(func(item *CacheItem) int64 {
+		return int64(len(item.Data().([]byte)))
+	})
+	table.SetMaxBytes(1000)
+
+	for i := 0; i < 100; i++ {
+		size := (i*37)%300 + 1
+		if _, err := table.AddChecked(i, time.Minute, make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+		if b := table.Bytes(); b > 1000 {
+			t.Fatalf("table grew to %d bytes", b)
+		}
+		if !table.Exists(i) {
+			t.Fatalf("item %d was evicted right away", i)
+		}
+	}
+	// the oldest items were evicted first
+	if table.Exists(0) {
+		t.Error("expected the least recently used item to be evicted")
+	}
+
+	var sum int64
+	table.Foreach(func(k interface{}, item *CacheItem) {
+		sum += int64(len(item.Data().([]byte)))
+	})
+	if sum != table.Bytes() {
+		t.Errorf("tracked %d bytes, items take %d", table.Bytes(), sum)
+	}
+
+	count := table.Count()
+	if _, err := table.AddChecked("huge", time.Minute, make([]byte, 1001)); err != ErrItemTooLarge {
+		t.Errorf("expected ErrItemTooLarge, got %v", err)
+	}
+	if table.Count() != count {
+		t.Error("rejecting a large item evicted other items")
+	}
+
+	table.Delete(99)
+	if table.Bytes() != sum-int64((99*37)%300+1) {
+		t.Error("delete didn't reduce the byte total")
+	}
+}
+
+// newFullTable return a table at its capacity of 3 items a, b and c, with b
+// accessed last and c accessed most
+func newFullTable(policy OverflowPolicy) (*CacheTable, *fakeClock) {
+	clock := newFakeClock()
+	table := newCacheTable("full")
+	table.SetClock(clock)
+	table.SetCapacity(3)
+	table.SetOverflowPolicy(policy)
+	for _, key := range []string{"a", "b", "c"} {
+		clock.Advance(time.Second)
+		table.Add(key, time.Hour, key)
+	}
+	clock.Advance(time.Second)
+	table.Value("c")
+	table.Value("c")
+	clock.Advance(time.Second)
+	table.Value("a")
+	clock.Advance(time.Second)
+	table.Value("b")
+	clock.Advance(time.Second)
+
+	return table, clock
+}
+
+// sortedKeys return the string keys of the table in order
+func sortedKeys(table *CacheTable) []string {
+	var keys []string
+	table.Foreach(func(k interface{}, item *CacheItem) {
+		keys = append(keys, k.(string))
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   OverflowPolicy
+		expected []string
+		err      error
+	}{
+		{"lru", EvictLRU, []string{"a", "b", "d"}, nil},
+		{"lfu", EvictLFU, []string{"b", "c", "d"}, nil},
+		{"reject", Reject, []string{"a", "b", "c"}, ErrCacheFull},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			table, _ := newFullTable(tc.policy)
+			r, err := table.AddChecked("d", time.Hour, "d")
+			if err != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if (err == nil) != (r != nil) {
+				t.Errorf("expected an item only on success, got %v", r)
+			}
+			if keys := sortedKeys(table); !reflect.DeepEqual(keys, tc.expected) {
+				t.Errorf("expected items %v, got %v", tc.expected, keys)
+			}
+		})
+	}
+}
+
+func TestPinnedSurvivesEviction(t *testing.T) {
+	table := newCacheTable("pinned")
+	table.SetCapacity(10)
+	table.Add("config", time.Hour, "data").Pin()
+
+	for i := 0; i < 1000; i++ {
+		if _, err := table.AddChecked(i, time.Hour, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !table.Exists("config") {
+		t.Fatal("pinned item was evicted")
+	}
+	if table.Count() != 10 {
+		t.Errorf("expected 10 items, got %d", table.Count())
+	}
+
+	// explicit deletes still work
+	if _, err := table.Delete("config"); err != nil || table.Exists("config") {
+		t.Errorf("pinned item could not be deleted: %v", err)
+	}
+}
+
+func TestAllPinned(t *testing.T) {
+	table := newCacheTable("pinned")
+	table.SetCapacity(2)
+	table.Add("a", time.Hour, "a").Pin()
+	b := table.Add("b", time.Hour, "b")
+	b.Pin()
+
+	if _, err := table.AddChecked("c", time.Hour, "c"); err != ErrCacheFull {
+		t.Errorf("expected ErrCacheFull, got %v", err)
+	}
+	if keys := sortedKeys(table); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("pinned items were evicted, got %v", keys)
+	}
+
+	b.Unpin()
+	if _, err := table.AddChecked("c", time.Hour, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := sortedKeys(table); !reflect.DeepEqual(keys, []string{"a", "c"}) {
+		t.Errorf("expected the unpinned item to be evicted, got %v", keys)
+	}
+}
+
+func TestZeroLifeSpanEvictable(t *testing.T) {
+	for _, evictable := range []bool{false, true} {
+		table := newCacheTable("zero")
+		table.SetCapacity(5)
+		table.SetZeroLifeSpanEvictable(evictable)
+		table.Add("forever", 0, "data")
+
+		for i := 0; i < 100; i++ {
+			table.Add(i, time.Hour, i)
+		}
+		if table.Exists("forever") == evictable {
+			t.Errorf("evictable %v: item which never expires kept %v", evictable, !evictable)
+		}
+		if table.Count() != 5 {
+			t.Errorf("evictable %v: expected 5 items, got %d", evictable, table.Count())
+		}
+	}
+
+	// with only items which never expire left, the add fails
+	table := newCacheTable("zero")
+	table.SetCapacity(1)
+	table.Add("forever", 0, "data")
+	if _, err := table.AddChecked("new", 0, "data"); err != ErrCacheFull {
+		t.Errorf("expected ErrCacheFull, got %v", err)
+	}
+}
+
+func TestEvictionSampling(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("sampled")
+	table.SetClock(clock)
+	table.SetCapacity(1000)
+	table.SetEvictionSampleSize(5)
+	// the key is the rank of the item by its last access
+	for i := 0; i < 1000; i++ {
+		clock.Advance(time.Second)
+		table.Add(i, time.Hour, i)
+	}
+	var evicted []int
+	table.SetEvictedItemCallback(func(item *CacheItem, reason EvictReason) {
+		if k, ok := item.Key().(int); ok {
+			evicted = append(evicted, k)
+		}
+	})
+
+	for i := 0; i < 100; i++ {
+		clock.Advance(time.Second)
+		table.Add(-i-1, time.Hour, i)
+	}
+	if len(evicted) != 100 || table.Count() != 1000 {
+		t.Fatalf("expected 100 evictions, got %d with %d items", len(evicted), table.Count())
+	}
+	// the best of 5 random items has an expected rank of about 1000/6
+	sum := 0
+	for _, k := range evicted {
+		if k < 0 {
+			t.Error("evicted one of the newest items")
+		}
+		sum += k
+	}
+	if avg := sum / len(evicted); avg > 350 {
+		t.Errorf("expected mostly old items to be evicted, got an average rank of %d", avg)
+	}
+}
+
+// benchmarkEviction adds items to a full table of n items, evicting one per add
+func benchmarkEviction(b *testing.B, n, sample int) {
+	table := newCacheTable("evict")
+	table.SetCapacity(n)
+	table.SetEvictionSampleSize(sample)
+	for i := 0; i < n; i++ {
+		table.Add(i, time.Hour, i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.Add(n+i, time.Hour, i)
+	}
+}
+
+// With sampling the cost of an eviction barely grows with the table, the exact
+// eviction ranks all items:
+//
+//	BenchmarkEvictionSampled1k      730459       1824 ns/op
+//	BenchmarkEvictionSampled100k    448381       3659 ns/op
+//	BenchmarkEvictionExact1k         10000     215227 ns/op
+//	BenchmarkEvictionExact100k          66   38731560 ns/op
+func BenchmarkEvictionSampled1k(b *testing.B)   { benchmarkEviction(b, 1000, 5) }
+func BenchmarkEvictionSampled100k(b *testing.B) { benchmarkEviction(b, 100000, 5) }
+func BenchmarkEvictionExact1k(b *testing.B)     { benchmarkEviction(b, 1000, 0) }
+func BenchmarkEvictionExact100k(b *testing.B)   { benchmarkEviction(b, 100000, 0) }
+
+func TestBorrowUnderEvictionPressure(t *testing.T) {
+	table := newCacheTable("borrowed")
+	table.SetCapacity(5)
+	table.Add("busy", time.Hour, "data")
+	r, release, ok := table.Borrow("busy")
+	if !ok {
+		t.Fatal("expected to borrow the item")
+	}
+
+	for i := 0; i < 100; i++ {
+		table.Add(i, time.Hour, i)
+	}
+	if !table.Exists("busy") {
+		t.Fatal("borrowed item was evicted")
+	}
+
+	release()
+	// release is idempotent
+	release()
+	for i := 100; i < 110; i++ {
+		table.Add(i, time.Hour, i)
+	}
+	if table.Exists("busy") {
+		t.Error("released item still protected from eviction")
+	}
+	if r.Data() != "data" {
+		t.Error("borrowed item changed")
+	}
+}
+
+func TestBorrowDelete(t *testing.T) {
+	table := newCacheTable("borrowed")
+	var deleted atomic.Int32
+	table.SetAboutToDeleteItemCallback(func(item *CacheItem) { deleted.Add(1) })
+	table.Add("busy", time.Hour, "data")
+	_, release1, _ := table.Borrow("busy")
+	_, release2, _ := table.Borrow("busy")
+
+	if _, err := table.Delete("busy"); err != nil {
+		t.Fatal(err)
+	}
+	if table.Exists("busy") {
+		t.Error("deleted item still visible")
+	}
+	release1()
+	if deleted.Load() != 0 {
+		t.Error("delete callback called while the item is still borrowed")
+	}
+	release2()
+	if deleted.Load() != 1 {
+		t.Errorf("expected one delete callback after the last release, got %d", deleted.Load())
+	}
+}
+
+func newBudgetTable() *CacheTable {
+	table := newCacheTable("bytes")
+	table.SetItemSizer(func(item *CacheItem) int64 {
+		return int64(len(item.Data().([]byte)))
+	})
+	table.SetMaxBytes(100)
+	clock := newFakeClock()
+	table.SetClock(clock)
+	for i := 0; i < 4; i++ {
+		clock.Skip(time.Second)
+		table.Add(i, time.Minute, make([]byte, 20))
+	}
+	return table
+}
+
+func TestUpdatesStayWithinBudget(t *testing.T) {
+	updates := map[string]func(table *CacheTable, data []byte) error{
+		"Replace": func(table *CacheTable, data []byte) error {
+			_, err := table.Replace(0, time.Minute, data)
+			return err
+		},
+		"AddOrRefresh": func(table *CacheTable, data []byte) error {
+			// failures are only logged, an unchanged item is returned
+			if r := table.AddOrRefresh(0, time.Minute, data); len(r.Data().([]byte)) != len(data) {
+				return ErrItemTooLarge
+			}
+			return nil
+		},
+		"CompareAndSwap": func(table *CacheTable, data []byte) error {
+			r, _ := table.Peek(0)
+			_, err := table.CompareAndSwap(0, r.Data(), data, time.Minute)
+			return err
+		},
+		"SwapValue": func(table *CacheTable, data []byte) error {
+			_, err := table.SwapValue(0, data)
+			return err
+		},
+	}
+	for name, update := range updates {
+		table := newBudgetTable()
+		if err := update(table, make([]byte, 90)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if b := table.Bytes(); b > 100 {
+			t.Errorf("%s: table grew to %d bytes", name, b)
+		}
+		if !table.Exists(0) || table.Count() != 1 {
+			t.Errorf("%s: expected the other items to be evicted, got %d items", name, table.Count())
+		}
+
+		table = newBudgetTable()
+		if err := update(table, make([]byte, 101)); err == nil {
+			t.Errorf("%s: expected updating with too large data to fail", name)
+		}
+		if r, _ := table.Peek(0); len(r.Data().([]byte)) != 20 || table.Bytes() != 80 {
+			t.Errorf("%s: expected a rejected update to leave the table untouched", name)
+		}
+	}
+}
+
+func TestUpdateEachStaysWithinBudget(t *testing.T) {
+	table := newBudgetTable()
+	table.UpdateEach(func(key interface{}, item *CacheItem) (interface{}, bool) {
+		switch key {
+		case 1:
+			return make([]byte, 101), true
+		case 2:
+			return make([]byte, 60), true
+		}
+		return item.Data(), true
+	})
+	if b := table.Bytes(); b > 100 {
+		t.Errorf("table grew to %d bytes", b)
+	}
+	if r, ok := table.Peek(1); ok && len(r.Data().([]byte)) != 20 {
+		t.Error("expected the too large update to be skipped")
+	}
+	// items are evicted by the overflow policy after all updates
+	if table.Count() != 3 || table.Exists(0) {
+		t.Errorf("expected the least recently used items to be evicted, got %d items", table.Count())
+	}
+}
+
+func TestUpdateRejectedOverBudget(t *testing.T) {
+	table := newBudgetTable()
+	table.SetOverflowPolicy(Reject)
+	if _, err := table.Replace(0, time.Minute, make([]byte, 90)); !errors.Is(err, ErrCacheFull) {
+		t.Errorf("expected ErrCacheFull, got %v", err)
+	}
+	if table.Count() != 4 || table.Bytes() != 80 {
+		t.Errorf("expected a rejected update to leave the table untouched, got %d items of %d bytes", table.Count(), table.Bytes())
+	}
+}
+
+func TestReAddCountsBytesOnce(t *testing.T) {
+	table := newBudgetTable()
+	r, _ := table.Peek(0)
+	table.AddBatch([]*CacheItem{r})
+	if table.Bytes() != 80 || table.Count() != 4 {
+		t.Errorf("expected re-adding to keep 4 items of 80 bytes, got %d items of %d bytes", table.Count(), table.Bytes())
+	}
+}
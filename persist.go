@@ -0,0 +1,209 @@
+package cpcache2go
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// persistedItem is the serialized form of a CacheItem
+type persistedItem struct {
+	Key         interface{}
+	Data        interface{}
+	LifeSpan    time.Duration
+	CreatedOn   time.Time
+	AccessedOn  time.Time
+	AccessCount int64
+	Meta        map[string]interface{}
+	Mode        ExpirationMode
+	ExpireAt    time.Time
+}
+
+// jsonItem is the JSON form of a CacheItem
+type jsonItem struct {
+	Data        json.RawMessage        `json:"data"`
+	LifeSpan    time.Duration          `json:"lifeSpan"`
+	CreatedOn   time.Time              `json:"createdOn"`
+	AccessedOn  time.Time              `json:"accessedOn"`
+	AccessCount int64                  `json:"accessCount"`
+	Meta        map[string]interface{} `json:"meta,omitempty"`
+	Mode        ExpirationMode         `json:"mode,omitempty"`
+	ExpireAt    time.Time              `json:"expireAt,omitzero"`
+}
+
+// SaveToWriter serializes all items of the table to w using encoding/gob.
+// Keys, data and metadata are stored as interface values, so their concrete
+// types (other than gob's basic types) must be registered with gob.Register by
+// the caller before saving and loading
+func (table *CacheTable) SaveToWriter(w io.Writer) error {
+	table.RLock()
+	items := make([]persistedItem, 0, len(table.items))
+	for _, item := range table.items {
+		item.RLock()
+		items = append(items, persistedItem{
+			Key:         item.key,
+			Data:        item.dataInternal(),
+			LifeSpan:    item.lifeSpan,
+			CreatedOn:   item.createdOn,
+			AccessedOn:  item.accessedOn,
+			AccessCount: item.accessCount,
+			Meta:        item.metaInternal(),
+			Mode:        item.mode,
+			ExpireAt:    item.expireAt,
+		})
+		item.RUnlock()
+	}
+	table.RUnlock()
+
+	return gob.NewEncoder(w).Encode(items)
+}
+
+// LoadFromReader adds all items previously written by SaveToWriter to the
+// table. The saved timestamps are kept, so items continue to expire where they
+// left off and items which already exceeded their lifespan or deadline are
+// dropped
+func (table *CacheTable) LoadFromReader(r io.Reader) error {
+	var saved []persistedItem
+	if err := gob.NewDecoder(r).Decode(&saved); err != nil {
+		return err
+	}
+
+	now := table.now()
+	items := make([]*CacheItem, 0, len(saved))
+	for _, p := range saved {
+		if expired(now, p.AccessedOn, p.CreatedOn, p.ExpireAt, p.LifeSpan, p.Mode) {
+			continue
+		}
+		items = append(items, &CacheItem{
+			key:         p.Key,
+			data:        p.Data,
+			lifeSpan:    p.LifeSpan,
+			createdOn:   p.CreatedOn,
+			accessedOn:  p.AccessedOn,
+			accessCount: p.AccessCount,
+			meta:        p.Meta,
+			mode:        p.Mode,
+			expireAt:    p.ExpireAt,
+			queueIndex:  -1,
+		})
+	}
+	table.addBatch(items, false)
+
+	return nil
+}
+
+// MarshalJSON encodes the table as a JSON object mapping each key to its data
+// and timestamps. Keys which aren't strings are stringified with fmt.Sprint,
+// a KeyError wrapping ErrKeyCollision is returned if two keys end up the same
+func (table *CacheTable) MarshalJSON() ([]byte, error) {
+	table.RLock()
+	defer table.RUnlock()
+
+	items := make(map[string]jsonItem, len(table.items))
+	for key, item := range table.items {
+		item.RLock()
+		name := fmt.Sprint(key)
+		if _, ok := items[name]; ok {
+			item.RUnlock()
+			return nil, &KeyError{Table: table.name, Key: key, Err: ErrKeyCollision}
+		}
+		data, err := json.Marshal(item.dataInternal())
+		if err != nil {
+			item.RUnlock()
+			return nil, err
+		}
+		items[name] = jsonItem{
+			Data:        data,
+			LifeSpan:    item.lifeSpan,
+			CreatedOn:   item.createdOn,
+			AccessedOn:  item.accessedOn,
+			AccessCount: item.accessCount,
+			Meta:        item.metaInternal(),
+			Mode:        item.mode,
+			ExpireAt:    item.expireAt,
+		}
+		item.RUnlock()
+	}
+
+	return json.Marshal(items)
+}
+
+// ImportJSON adds all items of a table previously encoded by MarshalJSON,
+// using decode to turn each raw data field back into a value. Keys are
+// imported as strings, metadata values as plain JSON values and items which
+// already exceeded their lifespan or deadline are dropped
+func (table *CacheTable) ImportJSON(r io.Reader, decode func(json.RawMessage) (interface{}, error)) error {
+	var saved map[string]jsonItem
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return err
+	}
+
+	now := table.now()
+	items := make([]*CacheItem, 0, len(saved))
+	for key, p := range saved {
+		if expired(now, p.AccessedOn, p.CreatedOn, p.ExpireAt, p.LifeSpan, p.Mode) {
+			continue
+		}
+		data, err := decode(p.Data)
+		if err != nil {
+			return err
+		}
+		items = append(items, &CacheItem{
+			key:         key,
+			data:        data,
+			lifeSpan:    p.LifeSpan,
+			createdOn:   p.CreatedOn,
+			accessedOn:  p.AccessedOn,
+			accessCount: p.AccessCount,
+			meta:        p.Meta,
+			mode:        p.Mode,
+			expireAt:    p.ExpireAt,
+			queueIndex:  -1,
+		})
+	}
+	table.addBatch(items, false)
+
+	return nil
+}
+
+// Export returns a copy of the data of all items by key
+func (table *CacheTable) Export() map[interface{}]interface{} {
+	table.RLock()
+	defer table.RUnlock()
+
+	m := make(map[interface{}]interface{}, len(table.items))
+	for key, item := range table.items {
+		m[key] = item.Data()
+	}
+
+	return m
+}
+
+// Import adds all key/value pairs of m to the table with the given lifespan
+func (table *CacheTable) Import(m map[interface{}]interface{}, lifeSpan time.Duration) {
+	table.RLock()
+	clock := table.clock
+	table.RUnlock()
+
+	items := make([]*CacheItem, 0, len(m))
+	for key, data := range m {
+		items = append(items, newCacheItem(clock, key, lifeSpan, data))
+	}
+	table.AddBatch(items)
+}
+
+// reports whether a persisted item exceeded its lifespan or hard deadline
+func expired(now, accessedOn, createdOn, expireAt time.Time, lifeSpan time.Duration, mode ExpirationMode) bool {
+	if !expireAt.IsZero() && !now.Before(expireAt) {
+		return true
+	}
+	if lifeSpan <= 0 {
+		return false
+	}
+	if mode == CreationTTL {
+		return now.Sub(createdOn) >= lifeSpan
+	}
+	return now.Sub(accessedOn) >= lifeSpan
+}
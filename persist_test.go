@@ -0,0 +1,193 @@
+package cpcache2go
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type session struct {
+	User  string
+	Roles []string
+}
+
+func init() {
+	gob.Register(session{})
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	table := newCacheTable("save")
+	table.Add("alice", time.Minute, session{User: "alice", Roles: []string{"admin"}})
+	table.Add("bob", 0, session{User: "bob"})
+	table.Value("alice")
+
+	var buf bytes.Buffer
+	if err := table.SaveToWriter(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newCacheTable("load")
+	if err := loaded.LoadFromReader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Count() != 2 {
+		t.Fatalf("expected 2 items, got %d", loaded.Count())
+	}
+	r, ok := loaded.Peek("alice")
+	if !ok {
+		t.Fatal("alice not loaded")
+	}
+	s, ok := r.Data().(session)
+	if !ok || s.User != "alice" || len(s.Roles) != 1 || s.Roles[0] != "admin" {
+		t.Errorf("unexpected data %#v", r.Data())
+	}
+	if r.AccessCount() != 1 || r.LifeSpan() != time.Minute {
+		t.Errorf("expected access count 1 and lifespan 1m, got %d and %v", r.AccessCount(), r.LifeSpan())
+	}
+	if _, ok := loaded.NextCleanup(); !ok {
+		t.Error("expiration check not armed after load")
+	}
+}
+
+func TestLoadDropsExpired(t *testing.T) {
+	// timestamps of the fake clock are long in the past
+	table := newCacheTable("save")
+	table.SetClock(newFakeClock())
+	table.Add("stale", time.Second, session{User: "stale"})
+	table.Add("forever", 0, session{User: "forever"})
+
+	var buf bytes.Buffer
+	if err := table.SaveToWriter(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newCacheTable("load")
+	if err := loaded.LoadFromReader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Exists("stale") || !loaded.Exists("forever") {
+		t.Error("expected only the item which never expires to be loaded")
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	table := newCacheTable("json")
+	table.Add("counts", time.Minute, map[string]int{"a": 1, "b": 2})
+	table.Add(42, 0, map[string]int{"answer": 42})
+
+	data, err := json.Marshal(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newCacheTable("import")
+	err = loaded.ImportJSON(bytes.NewReader(data), func(raw json.RawMessage) (interface{}, error) {
+		var m map[string]int
+		err := json.Unmarshal(raw, &m)
+		return m, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := loaded.Peek("counts")
+	if !ok {
+		t.Fatal("counts not imported")
+	}
+	if m := r.Data().(map[string]int); m["a"] != 1 || m["b"] != 2 {
+		t.Errorf("unexpected data %v", m)
+	}
+	// keys which aren't strings come back stringified
+	if _, ok := loaded.Peek("42"); !ok {
+		t.Error("stringified key not imported")
+	}
+}
+
+func TestJSONKeyCollision(t *testing.T) {
+	table := newCacheTable("json")
+	table.Add(1, 0, "int")
+	table.Add("1", 0, "string")
+
+	_, err := table.MarshalJSON()
+	var keyErr *KeyError
+	if !errors.Is(err, ErrKeyCollision) || !errors.As(err, &keyErr) {
+		t.Fatalf("expected ErrKeyCollision, got %v", err)
+	}
+	if keyErr.Table != "json" {
+		t.Errorf("expected table json, got %q", keyErr.Table)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	m := map[interface{}]interface{}{"a": 1, 2: "b", 3.5: []int{1, 2}}
+	table := newCacheTable("import")
+	table.Import(m, time.Minute)
+
+	if exported := table.Export(); !reflect.DeepEqual(exported, m) {
+		t.Errorf("expected %v, got %v", m, exported)
+	}
+	table.Foreach(func(k interface{}, item *CacheItem) {
+		if item.LifeSpan() != time.Minute {
+			t.Errorf("expected lifespan 1m for %v, got %v", k, item.LifeSpan())
+		}
+	})
+
+	// the export is a copy
+	exported := table.Export()
+	delete(exported, "a")
+	if !table.Exists("a") {
+		t.Error("changing the export changed the table")
+	}
+}
+
+func TestPersistDeadline(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("save")
+	table.SetClock(clock)
+	table.Add("capped", 0, "value")
+	table.Add("past", 0, "value")
+	table.ExpireAt("capped", clock.Now().Add(time.Hour))
+	table.ExpireAt("past", clock.Now().Add(time.Second))
+	// saved before the expiration timer of past fires
+	clock.Skip(2 * time.Second)
+
+	var gobBuf bytes.Buffer
+	if err := table.SaveToWriter(&gobBuf); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromGob := newCacheTable("gob")
+	fromGob.SetClock(clock)
+	if err := fromGob.LoadFromReader(&gobBuf); err != nil {
+		t.Fatal(err)
+	}
+	fromJSON := newCacheTable("json")
+	fromJSON.SetClock(clock)
+	decode := func(raw json.RawMessage) (interface{}, error) {
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	}
+	if err := fromJSON.ImportJSON(bytes.NewReader(data), decode); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, loaded := range []*CacheTable{fromGob, fromJSON} {
+		if loaded.Exists("past") {
+			t.Errorf("%s: expected the item past its deadline to be dropped", loaded.name)
+		}
+		r, ok := loaded.Peek("capped")
+		if !ok {
+			t.Fatalf("%s: capped not loaded", loaded.name)
+		}
+		if remaining := r.RemainingLife(); remaining != time.Hour-2*time.Second {
+			t.Errorf("%s: expected the deadline to be restored, %v left", loaded.name, remaining)
+		}
+	}
+}
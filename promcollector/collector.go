@@ -0,0 +1,68 @@
+// Package promcollector exports the statistics of all cpcache2go tables as
+// Prometheus metrics. It lives in its own package so the cache itself doesn't
+// depend on the Prometheus client library
+package promcollector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"wei/cpcache2go"
+)
+
+// Collector implements prometheus.Collector for all registered cache tables.
+// Counters are taken from CacheTable.Stats, so calling ResetStats on a table
+// shows up as a counter reset
+type Collector struct {
+	items       *prometheus.Desc
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	evictions   *prometheus.Desc
+	deletions   *prometheus.Desc
+	expirations *prometheus.Desc
+}
+
+// NewCollector return a new Collector, the metric names are prefixed with
+// the given namespace
+func NewCollector(namespace string) *Collector {
+	labels := []string{"table"}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "cache", name), help, labels, nil)
+	}
+
+	return &Collector{
+		items:       desc("items", "Number of items currently stored in the table."),
+		hits:        desc("hits_total", "Number of lookups served from the table."),
+		misses:      desc("misses_total", "Number of lookups of keys which were not in the table."),
+		evictions:   desc("evictions_total", "Number of items evicted to stay within the capacity or byte budget."),
+		deletions:   desc("deletions_total", "Number of items removed explicitly."),
+		expirations: desc("expirations_total", "Number of items removed because their lifespan ran out."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.items
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.deletions
+	ch <- c.expirations
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, name := range cpcache2go.Tables() {
+		table, ok := cpcache2go.LookupTable(name)
+		if !ok {
+			// dropped in the meantime
+			continue
+		}
+		stats := table.Stats()
+		ch <- prometheus.MustNewConstMetric(c.items, prometheus.GaugeValue, float64(table.Count()), name)
+		ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits), name)
+		ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses), name)
+		ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions), name)
+		ch <- prometheus.MustNewConstMetric(c.deletions, prometheus.CounterValue, float64(stats.Deletions), name)
+		ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(stats.Expirations), name)
+	}
+}
@@ -0,0 +1,45 @@
+package cpcache2go
+
+// ReadOnlyView gives access to the items of a table without allowing to add or
+// delete items. It shares the table's data, so reads see live updates
+type ReadOnlyView struct {
+	table *CacheTable
+}
+
+// ReadOnly return a read-only view of the table
+func (table *CacheTable) ReadOnly() *ReadOnlyView {
+	return &ReadOnlyView{table: table}
+}
+
+// Value returns an item from the cache and marks it to be kept alive, see
+// CacheTable.Value
+func (v *ReadOnlyView) Value(key interface{}, args ...interface{}) (*CacheItem, error) {
+	return v.table.Value(key, args...)
+}
+
+// Get returns an item from the cache and marks it to be kept alive without
+// calling the data-loader, see CacheTable.Get
+func (v *ReadOnlyView) Get(key interface{}) (*CacheItem, bool) {
+	return v.table.Get(key)
+}
+
+// Peek returns an item from the cache without marking it to be kept alive,
+// see CacheTable.Peek
+func (v *ReadOnlyView) Peek(key interface{}) (*CacheItem, bool) {
+	return v.table.Peek(key)
+}
+
+// Exists returns if an item exists in the cache
+func (v *ReadOnlyView) Exists(key interface{}) bool {
+	return v.table.Exists(key)
+}
+
+// Count return how many items are currently stored in the cache
+func (v *ReadOnlyView) Count() int {
+	return v.table.Count()
+}
+
+// Foreach all items in the table
+func (v *ReadOnlyView) Foreach(trans func(k interface{}, item *CacheItem)) {
+	v.table.Foreach(trans)
+}
@@ -0,0 +1,23 @@
+package cpcache2go
+
+import "testing"
+
+func TestReadOnlyView(t *testing.T) {
+	table := newCacheTable("view")
+	view := table.ReadOnly()
+	r := table.Add("key", 0, "data")
+
+	got, err := view.Value("key")
+	if err != nil || got != r {
+		t.Fatalf("expected the underlying item, got %v, %v", got, err)
+	}
+	if r.AccessCount() != 1 {
+		t.Errorf("expected the view to bump the access count, got %d", r.AccessCount())
+	}
+
+	// reads see live updates
+	table.Add("other", 0, "data")
+	if !view.Exists("other") || view.Count() != 2 {
+		t.Error("view doesn't see items added to the table")
+	}
+}
@@ -0,0 +1,98 @@
+package cpcache2go
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// ShardedTable spreads its items over several independent CacheTables, each
+// with its own lock and expiration timer, to reduce lock contention under
+// heavy concurrent load
+type ShardedTable struct {
+	// the table's name
+	name string
+	// the shards, a key always maps to the same shard
+	shards []*CacheTable
+}
+
+// NewShardedTable return a new sharded table with the given number of shards.
+// Sharded tables are not registered in the cache
+func NewShardedTable(name string, shards int) *ShardedTable {
+	if shards < 1 {
+		shards = 1
+	}
+	t := &ShardedTable{
+		name:   name,
+		shards: make([]*CacheTable, shards),
+	}
+	for i := range t.shards {
+		t.shards[i] = newCacheTable(name + "#" + strconv.Itoa(i))
+	}
+
+	return t
+}
+
+// shard return the table responsible for the given key
+func (t *ShardedTable) shard(key interface{}) *CacheTable {
+	h := fnv.New32a()
+	switch k := key.(type) {
+	case string:
+		h.Write([]byte(k))
+	default:
+		fmt.Fprint(h, k)
+	}
+
+	return t.shards[h.Sum32()%uint32(len(t.shards))]
+}
+
+// Shards return the underlying tables, e.g. to configure callbacks on them
+func (t *ShardedTable) Shards() []*CacheTable {
+	return t.shards
+}
+
+// Add adds a key/value pair to the cache
+func (t *ShardedTable) Add(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem {
+	return t.shard(key).Add(key, lifeSpan, data)
+}
+
+// Value returns an item from the cache and marks it to be kept alive
+func (t *ShardedTable) Value(key interface{}, args ...interface{}) (*CacheItem, error) {
+	return t.shard(key).Value(key, args...)
+}
+
+// Delete item from the cache
+func (t *ShardedTable) Delete(key interface{}) (*CacheItem, error) {
+	return t.shard(key).Delete(key)
+}
+
+// Exists returns if an item exists in the cache
+func (t *ShardedTable) Exists(key interface{}) bool {
+	return t.shard(key).Exists(key)
+}
+
+// Count return how many items are currently stored in all shards
+func (t *ShardedTable) Count() int {
+	count := 0
+	for _, s := range t.shards {
+		count += s.Count()
+	}
+
+	return count
+}
+
+// Foreach all items in all shards. Each shard is read-locked only while
+// it is being visited
+func (t *ShardedTable) Foreach(trans func(k interface{}, item *CacheItem)) {
+	for _, s := range t.shards {
+		s.Foreach(trans)
+	}
+}
+
+// Flush deletes all items in all shards
+func (t *ShardedTable) Flush() {
+	for _, s := range t.shards {
+		s.Flush()
+	}
+}
@@ -0,0 +1,79 @@
+package cpcache2go
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedTable(t *testing.T) {
+	table := NewShardedTable("sharded", 16)
+	for i := 0; i < 100; i++ {
+		table.Add(i, 0, i)
+	}
+	if table.Count() != 100 {
+		t.Errorf("expected 100 items, got %d", table.Count())
+	}
+	r, err := table.Value(42)
+	if err != nil || r.Data() != 42 {
+		t.Errorf("expected 42, got %v, %v", r, err)
+	}
+	if _, err := table.Delete(42); err != nil || table.Exists(42) {
+		t.Errorf("delete failed: %v", err)
+	}
+	table.Flush()
+	if table.Count() != 0 {
+		t.Errorf("expected an empty table, got %d items", table.Count())
+	}
+}
+
+// contended is the shared surface of CacheTable and ShardedTable used by the
+// benchmarks
+type contended interface {
+	Add(key interface{}, lifeSpan time.Duration, data interface{}) *CacheItem
+	Value(key interface{}, args ...interface{}) (*CacheItem, error)
+}
+
+// benchmarkContention runs b.N operations from 64 goroutines, one in ten
+// being an Add
+//
+// Results on a single core, where there's no contention to speak of so the
+// shards only pay for hashing the key. Sharding pays off with more cores:
+//
+//	BenchmarkContentionSingle    8564986    324.9 ns/op
+//	BenchmarkContentionSharded   7538154    313.3 ns/op
+func benchmarkContention(b *testing.B, table contended) {
+	const goroutines = 64
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		table.Add(keys[i], 0, i)
+	}
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < b.N; i += goroutines {
+				key := keys[i%len(keys)]
+				if i%10 == 0 {
+					table.Add(key, 0, i)
+				} else {
+					table.Value(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func BenchmarkContentionSingle(b *testing.B) {
+	benchmarkContention(b, newCacheTable("single"))
+}
+
+func BenchmarkContentionSharded(b *testing.B) {
+	benchmarkContention(b, NewShardedTable("sharded", 16))
+}
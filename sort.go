@@ -0,0 +1,106 @@
+package cpcache2go
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// SortKey selects the order in which ForeachSorted visits the items
+type SortKey int
+
+const (
+	// SortByKey visits the items in ascending key order. Keys are grouped by
+	// the name of their type, and keys of an integer, float or string kind are
+	// ordered by value within their type. Other keys of the same type are
+	// visited in no particular order
+	SortByKey SortKey = iota
+	// SortByCreatedOn visits the oldest items first
+	SortByCreatedOn
+	// SortByAccessCount visits the least accessed items first
+	SortByAccessCount
+)
+
+// sortEntry is a snapshot of an item taken for sorting
+type sortEntry struct {
+	key         interface{}
+	item        *CacheItem
+	createdOn   time.Time
+	accessCount int64
+}
+
+// ForeachSorted visits all items in the table in the order selected by by.
+// The items are copied into a sorted snapshot first, so trans is called
+// without holding the table lock and may modify the table
+func (table *CacheTable) ForeachSorted(by SortKey, trans func(k interface{}, item *CacheItem)) {
+	table.RLock()
+	entries := make([]sortEntry, 0, len(table.items))
+	for k, v := range table.items {
+		v.RLock()
+		entries = append(entries, sortEntry{
+			key:         k,
+			item:        v,
+			createdOn:   v.createdOn,
+			accessCount: v.accessCount,
+		})
+		v.RUnlock()
+	}
+	table.RUnlock()
+
+	switch by {
+	case SortByKey:
+		sort.SliceStable(entries, func(i, j int) bool {
+			return lessKey(entries[i].key, entries[j].key)
+		})
+	case SortByCreatedOn:
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].createdOn.Before(entries[j].createdOn)
+		})
+	case SortByAccessCount:
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].accessCount < entries[j].accessCount
+		})
+	}
+
+	for _, e := range entries {
+		trans(e.key, e.item)
+	}
+}
+
+// lessKey reports whether key a orders before key b. Keys are grouped by the
+// name of their type first, so the order stays transitive across kinds. Keys
+// of the same unorderable type are never less than each other
+func lessKey(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if ta, tb := keyType(va), keyType(vb); ta != tb {
+		return ta < tb
+	}
+	if !va.IsValid() || va.Type() != vb.Type() {
+		return false
+	}
+
+	switch va.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return va.Int() < vb.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return va.Uint() < vb.Uint()
+	case reflect.Float32, reflect.Float64:
+		// NaNs order before all other values
+		x, y := va.Float(), vb.Float()
+		return x < y || math.IsNaN(x) && !math.IsNaN(y)
+	case reflect.String:
+		return va.String() < vb.String()
+	}
+
+	return false
+}
+
+// keyType return the name of the type of a key, which groups the keys for
+// sorting
+func keyType(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	return v.Type().String()
+}
@@ -0,0 +1,67 @@
+package cpcache2go
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// visit return the keys of the table in the order visited by ForeachSorted
+func visit(table *CacheTable, by SortKey) []interface{} {
+	var keys []interface{}
+	table.ForeachSorted(by, func(k interface{}, item *CacheItem) {
+		keys = append(keys, k)
+	})
+	return keys
+}
+
+func TestForeachSortedByAccessCount(t *testing.T) {
+	table := newCacheTable("sorted")
+	for key, n := range map[string]int{"c": 3, "a": 0, "d": 5, "b": 1} {
+		table.Add(key, 0, key)
+		for i := 0; i < n; i++ {
+			table.Value(key)
+		}
+	}
+
+	keys := visit(table, SortByAccessCount)
+	if expected := []interface{}{"a", "b", "c", "d"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+}
+
+func TestForeachSortedByKey(t *testing.T) {
+	table := newCacheTable("sorted")
+	for _, key := range []interface{}{"b", 2, "a", 10, 1.5, math.NaN(), -1.0, int64(3)} {
+		table.Add(key, 0, key)
+	}
+
+	keys := visit(table, SortByKey)
+	// grouped by type name, NaN first among the floats
+	if len(keys) != 8 || !math.IsNaN(keys[0].(float64)) {
+		t.Fatalf("expected NaN first, got %v", keys)
+	}
+	expected := []interface{}{-1.0, 1.5, 2, 10, int64(3), "a", "b"}
+	if !reflect.DeepEqual(keys[1:], expected) {
+		t.Errorf("expected %v, got %v", expected, keys[1:])
+	}
+}
+
+func TestLessKeyTransitive(t *testing.T) {
+	keys := []interface{}{1, int64(1), "1", 1.0, uint(1), -1, "", math.NaN(), struct{}{}, nil}
+	for _, a := range keys {
+		if lessKey(a, a) {
+			t.Errorf("%#v orders before itself", a)
+		}
+		for _, b := range keys {
+			if lessKey(a, b) && lessKey(b, a) {
+				t.Errorf("%#v and %#v order before each other", a, b)
+			}
+			for _, c := range keys {
+				if lessKey(a, b) && lessKey(b, c) && !lessKey(a, c) {
+					t.Errorf("%#v < %#v < %#v but not %#v < %#v", a, b, c, a, c)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package cpcache2go
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats is a point-in-time copy of a table's usage counters
+type CacheStats struct {
+	// lookups served from the cache
+	Hits int64
+	// lookups of keys which were not in the cache
+	Misses int64
+	// misses resolved by the data-loader callback
+	LoaderSuccesses int64
+	// misses the data-loader callback could not resolve
+	LoaderFailures int64
+	// items evicted to stay within the capacity or byte budget
+	Evictions int64
+	// items removed explicitly, e.g. by Delete
+	Deletions int64
+	// items removed because their lifespan ran out
+	Expirations int64
+}
+
+// counters backing CacheStats, updated atomically so they can be read
+// without taking the table lock
+type tableStats struct {
+	hits            atomic.Int64
+	misses          atomic.Int64
+	loaderSuccesses atomic.Int64
+	loaderFailures  atomic.Int64
+	evictions       atomic.Int64
+	deletions       atomic.Int64
+	expirations     atomic.Int64
+}
+
+// Stats returns the usage counters of the table
+func (table *CacheTable) Stats() CacheStats {
+	return CacheStats{
+		Hits:            table.stats.hits.Load(),
+		Misses:          table.stats.misses.Load(),
+		LoaderSuccesses: table.stats.loaderSuccesses.Load(),
+		LoaderFailures:  table.stats.loaderFailures.Load(),
+		Evictions:       table.stats.evictions.Load(),
+		Deletions:       table.stats.deletions.Load(),
+		Expirations:     table.stats.expirations.Load(),
+	}
+}
+
+// ResetStats sets all usage counters of the table back to zero
+func (table *CacheTable) ResetStats() {
+	table.stats.hits.Store(0)
+	table.stats.misses.Store(0)
+	table.stats.loaderSuccesses.Store(0)
+	table.stats.loaderFailures.Store(0)
+	table.stats.evictions.Store(0)
+	table.stats.deletions.Store(0)
+	table.stats.expirations.Store(0)
+}
+
+// AgeHistogram counts the items by their age, the time since they were
+// created. Item i of the result counts the items no older than buckets[i] and
+// older than the previous boundary, the extra last item counts the items
+// older than the final boundary. The boundaries must be in ascending order
+func (table *CacheTable) AgeHistogram(buckets []time.Duration) []int {
+	table.RLock()
+	defer table.RUnlock()
+
+	now := table.clock.Now()
+	counts := make([]int, len(buckets)+1)
+	for _, v := range table.items {
+		age := now.Sub(v.createdOn)
+		counts[sort.Search(len(buckets), func(i int) bool { return age <= buckets[i] })]++
+	}
+
+	return counts
+}
+
+// AccessHistogram counts the items by their access count like AgeHistogram
+func (table *CacheTable) AccessHistogram(buckets []int64) []int {
+	table.RLock()
+	defer table.RUnlock()
+
+	counts := make([]int, len(buckets)+1)
+	for _, v := range table.items {
+		n := v.AccessCount()
+		counts[sort.Search(len(buckets), func(i int) bool { return n <= buckets[i] })]++
+	}
+
+	return counts
+}
@@ -0,0 +1,60 @@
+package cpcache2go
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistograms(t *testing.T) {
+	clock := newFakeClock()
+	table := newCacheTable("histogram")
+	table.SetClock(clock)
+	table.Add("a", 0, 0)
+	clock.Advance(30 * time.Second)
+	table.Add("b", 0, 0)
+	clock.Advance(30 * time.Second)
+	table.Add("c", 0, 0)
+	clock.Advance(60 * time.Second)
+	table.Add("d", 0, 0)
+	table.Add("e", 0, 0)
+
+	// ages of 120s, 90s, 60s, 0s and 0s, boundaries are inclusive
+	ages := table.AgeHistogram([]time.Duration{0, time.Minute, 100 * time.Second})
+	if expected := []int{2, 1, 1, 1}; !reflect.DeepEqual(ages, expected) {
+		t.Errorf("expected ages %v, got %v", expected, ages)
+	}
+
+	for key, n := range map[string]int{"b": 1, "c": 3, "d": 5, "e": 10} {
+		for i := 0; i < n; i++ {
+			table.Value(key)
+		}
+	}
+	accesses := table.AccessHistogram([]int64{0, 1, 5})
+	if expected := []int{1, 1, 2, 1}; !reflect.DeepEqual(accesses, expected) {
+		t.Errorf("expected access counts %v, got %v", expected, accesses)
+	}
+
+	if counts := table.AccessHistogram(nil); !reflect.DeepEqual(counts, []int{5}) {
+		t.Errorf("expected a single bucket without boundaries, got %v", counts)
+	}
+}
+
+func TestEvictionsAndDeletions(t *testing.T) {
+	table := newCacheTable("stats")
+	table.SetCapacity(2)
+	for i := 0; i < 3; i++ {
+		table.Add(i, time.Minute, i)
+	}
+	table.Delete(2)
+	table.DeleteBatch([]interface{}{1})
+
+	stats := table.Stats()
+	if stats.Evictions != 1 || stats.Deletions != 2 {
+		t.Errorf("expected 1 eviction and 2 deletions, got %d and %d", stats.Evictions, stats.Deletions)
+	}
+	table.ResetStats()
+	if stats := table.Stats(); stats.Evictions != 0 || stats.Deletions != 0 {
+		t.Errorf("expected the counters to be reset, got %+v", stats)
+	}
+}
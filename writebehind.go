@@ -0,0 +1,176 @@
+package cpcache2go
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MutationOp is the kind of change recorded by a Mutation
+type MutationOp int
+
+const (
+	// MutationWrite records an item added to the table
+	MutationWrite MutationOp = iota
+	// MutationDelete records an item deleted from the table
+	MutationDelete
+)
+
+// Mutation is a change to a table waiting to be written to a backing store
+type Mutation struct {
+	Op   MutationOp
+	Key  interface{}
+	Data interface{}
+}
+
+// WriteBehindOptions configures the write-behind mode of a table
+type WriteBehindOptions struct {
+	// Flush writes a batch of mutations to the backing store
+	Flush func(batch []Mutation) error
+	// BatchSize is the number of mutations which triggers a flush
+	BatchSize int
+	// Interval is the longest time a mutation waits for a flush, 0 means
+	// mutations are only flushed in full batches or on shutdown
+	Interval time.Duration
+	// Retries is how often a failed flush is retried
+	Retries int
+	// DeadLetter is called with batches which still failed after all retries
+	DeadLetter func(batch []Mutation, err error)
+	// BufferSize is the number of mutations which can be queued before
+	// changes of the table block
+	BufferSize int
+}
+
+// writeBehind batches the mutations of a table in the background
+type writeBehind struct {
+	opts WriteBehindOptions
+
+	// guards closed and sending on queue
+	mu     sync.RWMutex
+	closed bool
+	queue  chan Mutation
+	// closed once all mutations are flushed
+	done chan struct{}
+}
+
+// SetWriteBehind enables writing the changes of the table to a backing store
+// asynchronously in batches. The same changes are queued as for the
+// write-through callbacks, see SetWriteThrough. Flush runs in the background
+// and must not call back into the table, which may be blocked on a full
+// queue. A previously configured write-behind is shut down in the background,
+// use Shutdown first to wait for it
+func (table *CacheTable) SetWriteBehind(opts WriteBehindOptions) {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	w := &writeBehind{
+		opts:  opts,
+		queue: make(chan Mutation, opts.BufferSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+
+	table.Lock()
+	old := table.writeBehind
+	table.writeBehind = w
+	table.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+}
+
+// Close flushes all pending write-behind mutations and stops the write-behind
+func (table *CacheTable) Close() error {
+	return table.Shutdown(context.Background())
+}
+
+// Shutdown flushes all pending write-behind mutations and stops the
+// write-behind. It returns ctx.Err() if ctx is done before all mutations
+// were flushed
+func (table *CacheTable) Shutdown(ctx context.Context) error {
+	table.Lock()
+	w := table.writeBehind
+	table.writeBehind = nil
+	table.Unlock()
+
+	if w == nil {
+		return nil
+	}
+	w.close()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueue a mutation, blocking while the queue is full
+func (w *writeBehind) enqueue(m Mutation) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if !w.closed {
+		w.queue <- m
+	}
+}
+
+// stop accepting mutations and flush the pending ones
+func (w *writeBehind) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+}
+
+// collect mutations into batches until the queue is closed
+func (w *writeBehind) run() {
+	defer close(w.done)
+
+	var tick <-chan time.Time
+	if w.opts.Interval > 0 {
+		ticker := time.NewTicker(w.opts.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var batch []Mutation
+	for {
+		select {
+		case m, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, m)
+			if len(batch) >= w.opts.BatchSize {
+				w.flush(batch)
+				batch = nil
+			}
+		case <-tick:
+			w.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+// write a batch to the backing store, handing it to the dead-letter callback
+// if it still fails after all retries
+func (w *writeBehind) flush(batch []Mutation) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var err error
+	for attempt := 0; attempt <= w.opts.Retries; attempt++ {
+		if err = w.opts.Flush(batch); err == nil {
+			return
+		}
+	}
+	if w.opts.DeadLetter != nil {
+		w.opts.DeadLetter(batch, err)
+	}
+}
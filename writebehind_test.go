@@ -0,0 +1,103 @@
+package cpcache2go
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchRecorder collects the batches flushed by a write-behind
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]Mutation
+}
+
+func (r *batchRecorder) flush(batch []Mutation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, append([]Mutation(nil), batch...))
+	return nil
+}
+
+// sizes return the sizes of the flushed batches
+func (r *batchRecorder) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sizes []int
+	for _, b := range r.batches {
+		sizes = append(sizes, len(b))
+	}
+	return sizes
+}
+
+func TestWriteBehindBatchSize(t *testing.T) {
+	table := newCacheTable("behind")
+	var rec batchRecorder
+	table.SetWriteBehind(WriteBehindOptions{Flush: rec.flush, BatchSize: 3})
+
+	for i := 0; i < 7; i++ {
+		table.Add(i, 0, i)
+	}
+	table.Delete(0)
+	waitFor(t, func() bool { return len(rec.sizes()) == 2 })
+
+	// drains the rest
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sizes := rec.sizes(); !reflect.DeepEqual(sizes, []int{3, 3, 2}) {
+		t.Fatalf("expected batches of [3 3 2], got %v", sizes)
+	}
+	last := rec.batches[2]
+	if last[0] != (Mutation{Op: MutationWrite, Key: 6, Data: 6}) || last[1] != (Mutation{Op: MutationDelete, Key: 0}) {
+		t.Errorf("unexpected mutations %v", last)
+	}
+
+	// no longer queued after the shutdown
+	table.Add("late", 0, 1)
+	if len(rec.sizes()) != 3 {
+		t.Error("mutation flushed after Close")
+	}
+}
+
+func TestWriteBehindInterval(t *testing.T) {
+	table := newCacheTable("behind")
+	var rec batchRecorder
+	table.SetWriteBehind(WriteBehindOptions{Flush: rec.flush, BatchSize: 100, Interval: 10 * time.Millisecond})
+	defer table.Close()
+
+	table.Add("a", 0, 1)
+	table.Add("b", 0, 2)
+	waitFor(t, func() bool { return reflect.DeepEqual(rec.sizes(), []int{2}) })
+}
+
+func TestWriteBehindDeadLetter(t *testing.T) {
+	table := newCacheTable("behind")
+	failed := errors.New("store down")
+	var calls int
+	var dead []Mutation
+	table.SetWriteBehind(WriteBehindOptions{
+		Flush: func(batch []Mutation) error {
+			calls++
+			return failed
+		},
+		Retries: 2,
+		DeadLetter: func(batch []Mutation, err error) {
+			if err != failed {
+				t.Errorf("expected the flush error, got %v", err)
+			}
+			dead = batch
+		},
+	})
+
+	table.Add("a", 0, 1)
+	table.Close()
+	if calls != 3 {
+		t.Errorf("expected 3 flush attempts, got %d", calls)
+	}
+	if len(dead) != 1 || dead[0].Key != "a" {
+		t.Errorf("expected the batch in the dead letters, got %v", dead)
+	}
+}